@@ -0,0 +1,68 @@
+// Copyright (c) 2013 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fsm
+
+// Builder constructs a FSM using chainable method calls instead of the Events
+// and Callbacks passed to NewFSM.
+//
+// It has to be created with NewBuilder to function properly.
+type Builder struct {
+	initial   string
+	events    Events
+	callbacks Callbacks
+}
+
+// NewBuilder returns a Builder for a FSM starting in the initial state.
+func NewBuilder(initial string) *Builder {
+	return &Builder{
+		initial:   initial,
+		callbacks: make(Callbacks),
+	}
+}
+
+// AddTransition adds a transition from src to dst for the named event.
+func (b *Builder) AddTransition(event, src, dst string) *Builder {
+	b.events = append(b.events, EventDesc{Name: event, Src: []string{src}, Dst: dst})
+	return b
+}
+
+// OnBeforeEvent sets the before_<EVENT> callback.
+func (b *Builder) OnBeforeEvent(event string, fn Callback) *Builder {
+	b.callbacks["before_"+event] = fn
+	return b
+}
+
+// OnLeave sets the leave_<STATE> callback.
+func (b *Builder) OnLeave(state string, fn Callback) *Builder {
+	b.callbacks["leave_"+state] = fn
+	return b
+}
+
+// OnEnter sets the enter_<STATE> callback.
+func (b *Builder) OnEnter(state string, fn Callback) *Builder {
+	b.callbacks["enter_"+state] = fn
+	return b
+}
+
+// OnAfterEvent sets the after_<EVENT> callback.
+func (b *Builder) OnAfterEvent(event string, fn Callback) *Builder {
+	b.callbacks["after_"+event] = fn
+	return b
+}
+
+// Build constructs the FSM with NewFSM.
+func (b *Builder) Build() *FSM {
+	return NewFSM(b.initial, b.events, b.callbacks)
+}
@@ -0,0 +1,73 @@
+// Copyright (c) 2013 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fsm
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBuilder(t *testing.T) {
+	var calls []string
+	record := func(name string) Callback {
+		return func(e *Event) {
+			calls = append(calls, name)
+		}
+	}
+
+	built := NewBuilder("closed").
+		AddTransition("open", "closed", "open").
+		AddTransition("close", "open", "closed").
+		OnBeforeEvent("open", record("before_open")).
+		OnLeave("closed", record("leave_closed")).
+		OnEnter("open", record("enter_open")).
+		OnAfterEvent("open", record("after_open")).
+		Build()
+
+	expected := NewFSM(
+		"closed",
+		Events{
+			{Name: "open", Src: []string{"closed"}, Dst: "open"},
+			{Name: "close", Src: []string{"open"}, Dst: "closed"},
+		},
+		Callbacks{
+			"before_open":  record("before_open"),
+			"leave_closed": record("leave_closed"),
+			"enter_open":   record("enter_open"),
+			"after_open":   record("after_open"),
+		},
+	)
+
+	if built.Current() != expected.Current() {
+		t.Error("expected the same initial state")
+	}
+	if !reflect.DeepEqual(built.transitions, expected.transitions) {
+		t.Errorf("expected transitions %v, got %v", expected.transitions, built.transitions)
+	}
+	if len(built.callbacks) != len(expected.callbacks) {
+		t.Errorf("expected %d callbacks, got %d", len(expected.callbacks), len(built.callbacks))
+	}
+	for k := range expected.callbacks {
+		if _, ok := built.callbacks[k]; !ok {
+			t.Errorf("expected callback %v", k)
+		}
+	}
+
+	built.Event("open")
+	expectedCalls := []string{"before_open", "leave_closed", "enter_open", "after_open"}
+	if !reflect.DeepEqual(calls, expectedCalls) {
+		t.Errorf("expected %v, got %v", expectedCalls, calls)
+	}
+}
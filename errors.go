@@ -14,15 +14,81 @@
 
 package fsm
 
+import (
+	"fmt"
+	"strconv"
+)
+
 // InvalidEventError is returned by FSM.Event() when the event cannot be called
-// in the current state.
+// in the current state. Alternatives lists the events available in the state
+// instead, with their descriptions, as suggested by the error message, for
+// example "lock (lock the door) or open". It is only set if enabled with
+// FSM.SetSuggestAlternatives().
 type InvalidEventError struct {
+	Event        string
+	State        string
+	Alternatives string
+}
+
+func (e InvalidEventError) Error() string {
+	msg := "event " + e.Event + " inappropriate in current state " + e.State
+	if e.Alternatives != "" {
+		return msg + ", try " + e.Alternatives + " instead"
+	}
+	return msg
+}
+
+// GuardFailedError is returned by FSM.Event() when the guards of all
+// transitions for the event in the current state failed.
+type GuardFailedError struct {
 	Event string
 	State string
 }
 
-func (e InvalidEventError) Error() string {
-	return "event " + e.Event + " inappropriate in current state " + e.State
+func (e GuardFailedError) Error() string {
+	return "event " + e.Event + " guard failed in current state " + e.State
+}
+
+// InvalidDestinationError is returned by FSM.Event() when the destination
+// chosen for the event is not a defined state.
+type InvalidDestinationError struct {
+	Event string
+	State string
+}
+
+func (e InvalidDestinationError) Error() string {
+	return "event " + e.Event + " has invalid destination state " + e.State
+}
+
+// TerminalStateError is returned by FSM.Event() when the current state is a
+// final state.
+type TerminalStateError struct {
+	Event string
+	State string
+}
+
+func (e TerminalStateError) Error() string {
+	return "event " + e.Event + " inappropriate in final state " + e.State
+}
+
+// DisabledEventError is returned by FSM.Event() when the event has been
+// disabled with FSM.DisableEvent().
+type DisabledEventError struct {
+	Event string
+}
+
+func (e DisabledEventError) Error() string {
+	return "event " + e.Event + " is disabled"
+}
+
+// FrozenError is returned by FSM.Event() and FSM.Transition() while the FSM is
+// frozen with FSM.Freeze().
+type FrozenError struct {
+	Event string
+}
+
+func (e FrozenError) Error() string {
+	return "event " + e.Event + " rejected because the FSM is frozen"
 }
 
 // UnknownEventError is returned by FSM.Event() when the event is not defined.
@@ -34,13 +100,82 @@ func (e UnknownEventError) Error() string {
 	return "event " + e.Event + " does not exist"
 }
 
+// UnknownStateError is returned by FSM.RestoreState() and FSM.UnmarshalJSON()
+// when the state is not defined.
+type UnknownStateError struct {
+	State string
+}
+
+func (e UnknownStateError) Error() string {
+	return "state " + e.State + " does not exist"
+}
+
+// EmptyStateError is returned by NewFSMWithError() when the initial state, or
+// a source or destination state of an event, is empty. Event is empty for the
+// initial state.
+type EmptyStateError struct {
+	Event string
+}
+
+func (e EmptyStateError) Error() string {
+	if e.Event != "" {
+		return "empty state in event " + e.Event
+	}
+	return "empty initial state"
+}
+
+// EmptyEventError is returned by NewFSMWithError() when an event name is
+// empty.
+type EmptyEventError struct{}
+
+func (e EmptyEventError) Error() string {
+	return "empty event name"
+}
+
+// UnreachableStateError is returned by FSM.Validate() for a state that can
+// never be entered.
+type UnreachableStateError struct {
+	State string
+}
+
+func (e UnreachableStateError) Error() string {
+	return "state " + e.State + " is unreachable"
+}
+
+// DeadEndStateError is returned by FSM.Validate() for a state without any
+// outgoing transitions.
+type DeadEndStateError struct {
+	State string
+}
+
+func (e DeadEndStateError) Error() string {
+	return "state " + e.State + " has no outgoing transitions"
+}
+
+// ConflictingTransitionError is returned by FSM.Validate() when an event is
+// defined more than once from the same state with different destinations.
+type ConflictingTransitionError struct {
+	Event string
+	State string
+}
+
+func (e ConflictingTransitionError) Error() string {
+	return "event " + e.Event + " has conflicting transitions from state " + e.State
+}
+
 // InTransitionError is returned by FSM.Event() when an asynchronous transition
-// is already in progress.
+// is already in progress. Src and Dst are the states of the transition in
+// progress.
 type InTransitionError struct {
 	Event string
+	Src   string
+	Dst   string
 }
 
 func (e InTransitionError) Error() string {
+	if e.Src != "" || e.Dst != "" {
+		return "event " + e.Event + " inappropriate because previous transition from " + e.Src + " to " + e.Dst + " did not complete"
+	}
 	return "event " + e.Event + " inappropriate because previous transition did not complete"
 }
 
@@ -78,6 +213,17 @@ func (e CanceledError) Error() string {
 	return "transition canceled"
 }
 
+// RollbackError is returned by FSM.Event() when an enter_ or after_ callback
+// has set an error and the FSM was moved back to the source state. See
+// FSM.SetRollbackOnError().
+type RollbackError struct {
+	Err error
+}
+
+func (e RollbackError) Error() string {
+	return "transition rolled back with error: " + e.Err.Error()
+}
+
 // AsyncError is returned by FSM.Event() when a callback have initiated an
 // asynchronous state transition.
 type AsyncError struct {
@@ -91,6 +237,161 @@ func (e AsyncError) Error() string {
 	return "async started"
 }
 
+// UninitializedError is returned when restoring the state of a FSM that was
+// not created with NewFSM.
+type UninitializedError struct{}
+
+func (e UninitializedError) Error() string {
+	return "fsm not initialized"
+}
+
+// StaleSnapshotError is returned by FSM.Restore() when the state has changed
+// since the snapshot was taken.
+type StaleSnapshotError struct{}
+
+func (e StaleSnapshotError) Error() string {
+	return "snapshot is stale"
+}
+
+// ForeignSnapshotError is returned by FSM.Restore() for a snapshot that was not
+// taken from the FSM, including the zero StateSnapshot.
+type ForeignSnapshotError struct{}
+
+func (e ForeignSnapshotError) Error() string {
+	return "snapshot is not from this fsm"
+}
+
+// SequenceError is returned by FSM.FireSequence() when an event in the
+// sequence fails. Index is the position of the event in the sequence.
+type SequenceError struct {
+	Index int
+	Event string
+	Err   error
+}
+
+func (e SequenceError) Error() string {
+	return "event " + e.Event + " at index " + strconv.Itoa(e.Index) + " failed: " + e.Err.Error()
+}
+
+// CallbackPanicError is returned by FSM.Event() and FSM.Transition() when a
+// callback has panicked and panic recovery is enabled. See
+// FSM.SetRecoverPanics().
+type CallbackPanicError struct {
+	Value interface{}
+}
+
+func (e CallbackPanicError) Error() string {
+	return fmt.Sprintf("callback panicked: %v", e.Value)
+}
+
+// NoPathError is returned by FSM.PathTo() when the destination state can not
+// be reached from the source state.
+type NoPathError struct {
+	Src string
+	Dst string
+}
+
+func (e NoPathError) Error() string {
+	return "no path from " + e.Src + " to " + e.Dst
+}
+
+// MissingFieldError is returned by LoadJSON() when a required field of the
+// definition is missing or empty.
+type MissingFieldError struct {
+	Field string
+}
+
+func (e MissingFieldError) Error() string {
+	return "missing field " + e.Field
+}
+
+// AmbiguousNameError is returned by NewTypedFSM() when different events or
+// different states have the same name, or a state has the name of AnyState.
+type AmbiguousNameError struct {
+	Name string
+}
+
+func (e AmbiguousNameError) Error() string {
+	return "name " + e.Name + " is ambiguous"
+}
+
+// GroupConflictError is returned by VisualizeGrouped() when a state is in more
+// than one group.
+type GroupConflictError struct {
+	State string
+}
+
+func (e GroupConflictError) Error() string {
+	return "state " + e.State + " is in more than one group"
+}
+
+// CallbackTimeoutError is returned by FSM.Event() and FSM.Transition() when
+// the callbacks of a phase do not complete within the timeout set with
+// FSM.SetCallbackTimeout().
+type CallbackTimeoutError struct {
+	Event string
+	Phase string
+}
+
+func (e CallbackTimeoutError) Error() string {
+	return "event " + e.Event + " timed out in the " + e.Phase + " callbacks"
+}
+
+// TransitionDepthExceededError is returned by FSM.Event() when queueing the
+// event would exceed the depth set with FSM.SetMaxTransitionDepth().
+type TransitionDepthExceededError struct {
+	Event string
+	Depth int
+}
+
+func (e TransitionDepthExceededError) Error() string {
+	return "event " + e.Event + " exceeds the maximum transition depth " + strconv.Itoa(e.Depth)
+}
+
+// UnexpectedStateError is returned by FSM.EventIf() when the FSM is not in the
+// expected state.
+type UnexpectedStateError struct {
+	Expected string
+	Actual   string
+}
+
+func (e UnexpectedStateError) Error() string {
+	return "expected state " + e.Expected + " but is in state " + e.Actual
+}
+
+// ReplayMismatchError is returned by FSM.Replay() when replaying the record at
+// Index diverges from it. Expected is the recorded state and Actual the state
+// of the FSM, before the event if the source state differs and after it
+// otherwise. Err is the error of the event, if it failed.
+type ReplayMismatchError struct {
+	Index    int
+	Event    string
+	Expected string
+	Actual   string
+	Err      error
+}
+
+func (e ReplayMismatchError) Error() string {
+	msg := "replay of event " + e.Event + " at record " + strconv.Itoa(e.Index) +
+		" expected state " + e.Expected + " but is in state " + e.Actual
+	if e.Err != nil {
+		return msg + " with error: " + e.Err.Error()
+	}
+	return msg
+}
+
+// UnencodableTransitionError is returned by FSM.GobEncode() for a transition
+// with a guard, a Choose function, an action or metadata, which can not be
+// encoded.
+type UnencodableTransitionError struct {
+	Event string
+	Src   string
+}
+
+func (e UnencodableTransitionError) Error() string {
+	return "transition of event " + e.Event + " from state " + e.Src + " can not be encoded"
+}
+
 // InternalError is returned by FSM.Event() and should never occur. It is a
 // probably because of a bug.
 type InternalError struct{}
@@ -26,6 +26,57 @@ func TestInvalidEventError(t *testing.T) {
 	if e.Error() != "event "+e.Event+" inappropriate in current state "+e.State {
 		t.Error("InvalidEventError string mismatch")
 	}
+	e.Alternatives = "open"
+	if e.Error() != "event "+e.Event+" inappropriate in current state "+e.State+", try open instead" {
+		t.Error("InvalidEventError string mismatch")
+	}
+	e.Alternatives = "kick, lock or open"
+	if e.Error() != "event "+e.Event+" inappropriate in current state "+e.State+", try kick, lock or open instead" {
+		t.Error("InvalidEventError string mismatch")
+	}
+}
+
+func TestGuardFailedError(t *testing.T) {
+	event := "guarded event"
+	state := "state"
+	e := GuardFailedError{Event: event, State: state}
+	if e.Error() != "event "+e.Event+" guard failed in current state "+e.State {
+		t.Error("GuardFailedError string mismatch")
+	}
+}
+
+func TestInvalidDestinationError(t *testing.T) {
+	event := "event"
+	state := "invalid state"
+	e := InvalidDestinationError{Event: event, State: state}
+	if e.Error() != "event "+e.Event+" has invalid destination state "+e.State {
+		t.Error("InvalidDestinationError string mismatch")
+	}
+}
+
+func TestTerminalStateError(t *testing.T) {
+	event := "event"
+	state := "final state"
+	e := TerminalStateError{Event: event, State: state}
+	if e.Error() != "event "+e.Event+" inappropriate in final state "+e.State {
+		t.Error("TerminalStateError string mismatch")
+	}
+}
+
+func TestDisabledEventError(t *testing.T) {
+	event := "event"
+	e := DisabledEventError{Event: event}
+	if e.Error() != "event "+e.Event+" is disabled" {
+		t.Error("DisabledEventError string mismatch")
+	}
+}
+
+func TestFrozenError(t *testing.T) {
+	event := "event"
+	e := FrozenError{Event: event}
+	if e.Error() != "event "+e.Event+" rejected because the FSM is frozen" {
+		t.Error("FrozenError string mismatch")
+	}
 }
 
 func TestUnknownEventError(t *testing.T) {
@@ -36,12 +87,68 @@ func TestUnknownEventError(t *testing.T) {
 	}
 }
 
+func TestUnknownStateError(t *testing.T) {
+	state := "invalid state"
+	e := UnknownStateError{State: state}
+	if e.Error() != "state "+e.State+" does not exist" {
+		t.Error("UnknownStateError string mismatch")
+	}
+}
+
+func TestEmptyStateError(t *testing.T) {
+	e := EmptyStateError{}
+	if e.Error() != "empty initial state" {
+		t.Error("EmptyStateError string mismatch")
+	}
+	event := "event"
+	e = EmptyStateError{Event: event}
+	if e.Error() != "empty state in event "+e.Event {
+		t.Error("EmptyStateError string mismatch")
+	}
+}
+
+func TestEmptyEventError(t *testing.T) {
+	e := EmptyEventError{}
+	if e.Error() != "empty event name" {
+		t.Error("EmptyEventError string mismatch")
+	}
+}
+
+func TestUnreachableStateError(t *testing.T) {
+	state := "unreachable state"
+	e := UnreachableStateError{State: state}
+	if e.Error() != "state "+e.State+" is unreachable" {
+		t.Error("UnreachableStateError string mismatch")
+	}
+}
+
+func TestDeadEndStateError(t *testing.T) {
+	state := "dead end state"
+	e := DeadEndStateError{State: state}
+	if e.Error() != "state "+e.State+" has no outgoing transitions" {
+		t.Error("DeadEndStateError string mismatch")
+	}
+}
+
+func TestConflictingTransitionError(t *testing.T) {
+	event := "conflicting event"
+	state := "state"
+	e := ConflictingTransitionError{Event: event, State: state}
+	if e.Error() != "event "+e.Event+" has conflicting transitions from state "+e.State {
+		t.Error("ConflictingTransitionError string mismatch")
+	}
+}
+
 func TestInTransitionError(t *testing.T) {
 	event := "in transition"
 	e := InTransitionError{Event: event}
 	if e.Error() != "event "+e.Event+" inappropriate because previous transition did not complete" {
 		t.Error("InTransitionError string mismatch")
 	}
+	e = InTransitionError{Event: event, Src: "src", Dst: "dst"}
+	if e.Error() != "event "+e.Event+" inappropriate because previous transition from "+e.Src+" to "+e.Dst+" did not complete" {
+		t.Error("InTransitionError string mismatch")
+	}
 }
 
 func TestNotInTransitionError(t *testing.T) {
@@ -73,6 +180,13 @@ func TestCanceledError(t *testing.T) {
 	}
 }
 
+func TestRollbackError(t *testing.T) {
+	e := RollbackError{Err: errors.New("rollback")}
+	if e.Error() != "transition rolled back with error: "+e.Err.Error() {
+		t.Error("RollbackError string mismatch")
+	}
+}
+
 func TestAsyncError(t *testing.T) {
 	e := AsyncError{}
 	if e.Error() != "async started" {
@@ -84,6 +198,109 @@ func TestAsyncError(t *testing.T) {
 	}
 }
 
+func TestUninitializedError(t *testing.T) {
+	e := UninitializedError{}
+	if e.Error() != "fsm not initialized" {
+		t.Error("UninitializedError string mismatch")
+	}
+}
+
+func TestStaleSnapshotError(t *testing.T) {
+	e := StaleSnapshotError{}
+	if e.Error() != "snapshot is stale" {
+		t.Error("StaleSnapshotError string mismatch")
+	}
+}
+
+func TestForeignSnapshotError(t *testing.T) {
+	e := ForeignSnapshotError{}
+	if e.Error() != "snapshot is not from this fsm" {
+		t.Error("ForeignSnapshotError string mismatch")
+	}
+}
+
+func TestSequenceError(t *testing.T) {
+	e := SequenceError{Index: 2, Event: "event", Err: errors.New("failed")}
+	if e.Error() != "event "+e.Event+" at index 2 failed: "+e.Err.Error() {
+		t.Error("SequenceError string mismatch")
+	}
+}
+
+func TestCallbackPanicError(t *testing.T) {
+	e := CallbackPanicError{Value: "panic"}
+	if e.Error() != "callback panicked: panic" {
+		t.Error("CallbackPanicError string mismatch")
+	}
+}
+
+func TestNoPathError(t *testing.T) {
+	e := NoPathError{Src: "src", Dst: "dst"}
+	if e.Error() != "no path from "+e.Src+" to "+e.Dst {
+		t.Error("NoPathError string mismatch")
+	}
+}
+
+func TestMissingFieldError(t *testing.T) {
+	e := MissingFieldError{Field: "field"}
+	if e.Error() != "missing field "+e.Field {
+		t.Error("MissingFieldError string mismatch")
+	}
+}
+
+func TestAmbiguousNameError(t *testing.T) {
+	e := AmbiguousNameError{Name: "name"}
+	if e.Error() != "name "+e.Name+" is ambiguous" {
+		t.Error("AmbiguousNameError string mismatch")
+	}
+}
+
+func TestGroupConflictError(t *testing.T) {
+	state := "state"
+	e := GroupConflictError{State: state}
+	if e.Error() != "state "+e.State+" is in more than one group" {
+		t.Error("GroupConflictError string mismatch")
+	}
+}
+
+func TestCallbackTimeoutError(t *testing.T) {
+	e := CallbackTimeoutError{Event: "event", Phase: "enter"}
+	if e.Error() != "event "+e.Event+" timed out in the "+e.Phase+" callbacks" {
+		t.Error("CallbackTimeoutError string mismatch")
+	}
+}
+
+func TestTransitionDepthExceededError(t *testing.T) {
+	e := TransitionDepthExceededError{Event: "event", Depth: 3}
+	if e.Error() != "event "+e.Event+" exceeds the maximum transition depth 3" {
+		t.Error("TransitionDepthExceededError string mismatch")
+	}
+}
+
+func TestUnexpectedStateError(t *testing.T) {
+	e := UnexpectedStateError{Expected: "expected", Actual: "actual"}
+	if e.Error() != "expected state "+e.Expected+" but is in state "+e.Actual {
+		t.Error("UnexpectedStateError string mismatch")
+	}
+}
+
+func TestReplayMismatchError(t *testing.T) {
+	e := ReplayMismatchError{Index: 2, Event: "event", Expected: "expected", Actual: "actual"}
+	if e.Error() != "replay of event "+e.Event+" at record 2 expected state "+e.Expected+" but is in state "+e.Actual {
+		t.Error("ReplayMismatchError string mismatch")
+	}
+	e.Err = errors.New("error")
+	if e.Error() != "replay of event "+e.Event+" at record 2 expected state "+e.Expected+" but is in state "+e.Actual+" with error: error" {
+		t.Error("ReplayMismatchError string mismatch")
+	}
+}
+
+func TestUnencodableTransitionError(t *testing.T) {
+	e := UnencodableTransitionError{Event: "event", Src: "src"}
+	if e.Error() != "transition of event "+e.Event+" from state "+e.Src+" can not be encoded" {
+		t.Error("UnencodableTransitionError string mismatch")
+	}
+}
+
 func TestInternalError(t *testing.T) {
 	e := InternalError{}
 	if e.Error() != "internal error on state transition" {
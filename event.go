@@ -14,6 +14,8 @@
 
 package fsm
 
+import "context"
+
 // Event is the info that get passed as a reference in the callbacks.
 type Event struct {
 	// FSM is a reference to the current FSM.
@@ -37,8 +39,27 @@ type Event struct {
 	// canceled is an internal flag set if the transition is canceled.
 	canceled bool
 
+	// cancelErr is the error set with CancelWith, returned instead of
+	// CanceledError.
+	cancelErr error
+
 	// async is an internal flag set if the transition should be asynchronous
 	async bool
+
+	// ctx is the context the event was fired with.
+	ctx context.Context
+
+	// data holds the values stored with Set during the transition.
+	data map[string]interface{}
+
+	// meta is the metadata of the transition, shared with the FSM.
+	meta map[string]interface{}
+
+	// next is the event scheduled with NextEvent.
+	next *queuedEvent
+
+	// payload is the payload passed to FSM.EventWithPayload.
+	payload interface{}
 }
 
 // Cancel can be called in before_<EVENT> or leave_<STATE> to cancel the
@@ -52,6 +73,22 @@ func (e *Event) Cancel(err ...error) {
 	}
 }
 
+// CancelWith cancels the current transition like Cancel, but makes Event
+// return err itself instead of a CanceledError. It also sets e.Err to err.
+func (e *Event) CancelWith(err error) {
+	e.canceled = true
+	e.cancelErr = err
+	e.Err = err
+}
+
+// canceledError returns the error for a canceled transition.
+func (e *Event) canceledError() error {
+	if e.cancelErr != nil {
+		return e.cancelErr
+	}
+	return CanceledError{e.Err}
+}
+
 // Async can be called in leave_<STATE> to do an asynchronous state transition.
 //
 // The current state transition will be on hold in the old state until a final
@@ -60,3 +97,91 @@ func (e *Event) Cancel(err ...error) {
 func (e *Event) Async() {
 	e.async = true
 }
+
+// NextEvent schedules event to be fired with args as soon as the current
+// transition has completed, like a tail call, for example from the enter_
+// callbacks of a decision state that immediately moves on. It is fired with the
+// same context and payload and is not affected by the pending transition, so
+// it does not fail with InTransitionError. Event, or Transition for an
+// asynchronous transition, then returns the error of the scheduled event.
+//
+// Only the last scheduled event is fired. It is dropped if the transition does
+// not complete, for example because it is canceled or an error is set on the
+// event, and for events that do not change the state.
+func (e *Event) NextEvent(event string, args ...interface{}) {
+	e.next = &queuedEvent{ctx: e.Context(), event: event, payload: e.payload, args: args}
+}
+
+// Context returns the context the event was fired with. It is
+// context.Background() for events fired with FSM.Event.
+func (e *Event) Context() context.Context {
+	if e.ctx == nil {
+		return context.Background()
+	}
+	return e.ctx
+}
+
+// Arg returns the argument at index i of Args. It returns false if there is no
+// such argument.
+func (e *Event) Arg(i int) (interface{}, bool) {
+	if i < 0 || i >= len(e.Args) {
+		return nil, false
+	}
+	return e.Args[i], true
+}
+
+// StringArg returns the argument at index i of Args if it is a string. It
+// returns false if there is no such argument or it is not a string.
+func (e *Event) StringArg(i int) (string, bool) {
+	arg, _ := e.Arg(i)
+	s, ok := arg.(string)
+	return s, ok
+}
+
+// IntArg returns the argument at index i of Args if it is an int. It returns
+// false if there is no such argument or it is not an int.
+func (e *Event) IntArg(i int) (int, bool) {
+	arg, _ := e.Arg(i)
+	n, ok := arg.(int)
+	return n, ok
+}
+
+// Payload returns the payload the event was fired with by
+// FSM.EventWithPayload, or nil if there is none.
+func (e *Event) Payload() interface{} {
+	return e.payload
+}
+
+// Set stores a value in the event for the later callbacks of the same
+// transition, including callbacks called by an asynchronous Transition.
+func (e *Event) Set(key string, val interface{}) {
+	if e.data == nil {
+		e.data = make(map[string]interface{})
+	}
+	e.data[key] = val
+}
+
+// Get returns a value stored in the event with Set.
+func (e *Event) Get(key string) (interface{}, bool) {
+	val, ok := e.data[key]
+	return val, ok
+}
+
+// Meta returns a copy of the metadata of the transition, as defined in
+// EventDesc.Meta, or nil if there is none. It is set once the transition has
+// been found and is also available to the guard.
+func (e *Event) Meta() map[string]interface{} {
+	return copyMeta(e.meta)
+}
+
+// copyMeta returns a copy of the transition metadata m, or nil if it is empty.
+func copyMeta(m map[string]interface{}) map[string]interface{} {
+	if len(m) == 0 {
+		return nil
+	}
+	c := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
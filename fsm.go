@@ -25,38 +25,197 @@
 package fsm
 
 import (
+	"context"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
-// transitioner is an interface for the FSM's transition function.
-type transitioner interface {
-	transition(*FSM) error
+// AnyState is a wildcard source state matching every state. Transitions with
+// AnyState as source are used when no transition is defined for the event from
+// the current state.
+const AnyState = "*"
+
+// Transitioner is an interface for the FSM's transition function. It is
+// called to complete a state change once the leave state callbacks have run.
+// Custom implementations, for example for instrumentation, can be set with
+// WithTransitioner and should normally delegate to DefaultTransitioner.
+type Transitioner interface {
+	Transition(*FSM) error
 }
 
 // FSM is the state machine that holds the current state.
 //
 // It has to be created with NewFSM to function properly.
 type FSM struct {
+	// initial is the state that the FSM was created in.
+	initial string
+	// initialEntered is true once FireInitialEnter has been called. It is
+	// guarded by eventMu.
+	initialEntered bool
+
 	// current is the state that the FSM is currently in.
 	current string
+	// currentValue holds a copy of current for reading it without locking.
+	// It is only stored by setCurrent and the constructors and always holds a
+	// string.
+	currentValue atomic.Value
+
+	// transitions maps events and source states to destination states, in
+	// definition order.
+	transitions map[eKey][]eDst
+
+	// aliases maps event aliases to the event names.
+	aliases map[string]string
+
+	// final is the set of final states, guarded by stateMu.
+	final map[string]bool
+
+	// disabled is the set of events disabled with DisableEvent, guarded by
+	// stateMu.
+	disabled map[string]bool
+
+	// frozen is true if all events are rejected, guarded by stateMu.
+	frozen bool
+
+	// hooks maps callback types to the general callbacks added with
+	// AddBeforeEvent and similar, in the order added. It is modified with both
+	// eventMu and stateMu held.
+	hooks map[int][]Callback
 
-	// transitions maps events and source states to destination states.
-	transitions map[eKey]string
+	// states is the set of all states used as source or destination in the
+	// transitions.
+	states map[string]bool
 
-	// callbacks maps events and targers to callback functions.
+	// available maps states to the sorted events defined from them, including
+	// the events from AnyState. AnyState maps to the events for other states.
+	available map[string][]string
+
+	// callbacks maps events and targers to callback functions. It is modified
+	// with both eventMu and stateMu held.
 	callbacks map[cKey]Callback
 
 	// transition is the internal transition functions used either directly
 	// or when Transition is called in an asynchronous state transition.
-	transition func()
+	transition func() error
+	// strictTransitions is true if conflicting transitions are an error when
+	// constructing the FSM.
+	strictTransitions bool
+
+	// recoverPanics is true if panics in callbacks are returned as errors.
+	recoverPanics bool
+
+	// rollbackOnError is true if errors from the enter_ and after_ callbacks
+	// move the FSM back to the source state.
+	rollbackOnError bool
+
+	// callbackTimeout is the maximum duration of each phase of callbacks, or
+	// 0 for no limit.
+	callbackTimeout time.Duration
+
+	// selfTransitions is true if events with the current state as destination
+	// run the leave_ and enter_ callbacks instead of returning
+	// NoTransitionError.
+	selfTransitions bool
+
+	// suggestAlternatives is true if InvalidEventError suggests the events
+	// available in the state instead.
+	suggestAlternatives bool
+
+	// logger logs the phases of the events, if set.
+	logger Logger
+
+	// observer is called after every completed transition.
+	observer Observer
+
+	// unknownEventHandler is called for events that are not defined.
+	unknownEventHandler func(event string, args ...interface{}) error
+
+	// errorObserver is called when an event fails.
+	errorObserver ErrorObserver
+	// observeNoTransition is true if errorObserver is called for
+	// NoTransitionError.
+	observeNoTransition bool
+
+	// descriptions are the descriptions of the events.
+	descriptions map[string]string
+
+	// normalizer normalizes the state and event names, if set.
+	normalizer func(string) string
+
+	// rejectHandler is called when an event is invalid or unknown.
+	rejectHandler RejectHandler
+
+	// next is the event scheduled with Event.NextEvent by the last completed
+	// transition, guarded by eventMu.
+	next *queuedEvent
+
+	// pending is the event of the pending transition, if any.
+	pending *Event
+
 	// transitionerObj calls the FSM's transition() function.
-	transitionerObj transitioner
+	transitionerObj Transitioner
 
 	// stateMu guards access to the current state.
 	stateMu sync.RWMutex
 	// eventMu guards access to Event() and Transition().
 	eventMu sync.Mutex
+	// callbacksRunning is the number of callbacks being called, updated
+	// atomically, so that SetState called from a callback does not wait for
+	// eventMu, which is held by the event.
+	callbacksRunning int32
+
+	// generation is the number of completed transitions.
+	generation uint64
+	// changes is the number of state changes, including those made without a
+	// transition like SetState, used to detect stale snapshots.
+	changes uint64
+	// lastTransition is when the last transition completed.
+	lastTransition time.Time
+	// enteredAt is when the current state was entered.
+	enteredAt time.Time
+
+	// history is a ring buffer of completed transitions, guarded by stateMu.
+	history []TransitionRecord
+	// historyNext is the index in history of the next record to write.
+	historyNext int
+	// historySize is the maximum number of records in history.
+	historySize int
+
+	// visits counts how many times each state has been entered, guarded by
+	// stateMu. It is nil if metrics are disabled.
+	visits map[string]int
+
+	// timeouts maps states to the event fired after a timeout in the state.
+	timeouts map[string]stateTimeout
+	// timer is the running timeout timer of the current state, if any.
+	timer *time.Timer
+	// timerGen is incremented on every state change to detect stale timers.
+	timerGen uint64
+
+	// queueEnabled is true if events fired during a transition are queued.
+	queueEnabled bool
+	// queue holds the events waiting for the current transition to complete.
+	queue []queuedEvent
+	// processing is true while an event or transition is processed in queued
+	// mode.
+	processing bool
+	// depth is the number of queued events leading to the event being
+	// processed, guarded by queueMu.
+	depth int
+	// maxDepth is the maximum depth of queued events, or 0 for no limit. It
+	// is guarded by queueMu.
+	maxDepth int
+	// queueMu guards access to the event queue.
+	queueMu sync.Mutex
+
+	// waiters are the WaitForState calls waiting for a state, guarded by
+	// stateMu.
+	waiters []stateWaiter
+	// subscribers are the channels returned by Subscribe, guarded by stateMu.
+	subscribers []chan StateChange
 }
 
 // EventDesc represents an event when initializing the FSM.
@@ -75,12 +234,109 @@ type EventDesc struct {
 	// Dst is the destination state that the FSM will be in if the transition
 	// succeds.
 	Dst string
+
+	// Guard is an optional condition that must return true for the transition
+	// to be performed. It is called with the event before any callbacks, and
+	// calling Cancel or Async on the event has no effect.
+	//
+	// Several transitions can be defined for the same event and source state
+	// with different guards, in which case they are tried in definition order
+	// and the first one with a passing guard is performed. A transition without
+	// a guard is the default, performed only if all guards fail, regardless of
+	// where it is defined. Without a default, the event then fails with
+	// GuardFailedError.
+	Guard func(*Event) bool
+
+	// Choose optionally picks the destination state when the transition is
+	// performed, overriding Dst. It is called with the event after the guard
+	// and before any callbacks, with Dst set to the static destination, if
+	// any. The chosen state must be used as a source or destination by some
+	// transition or the event fails with an InvalidDestinationError.
+	Choose func(*Event) string
+
+	// Aliases are other names for the event. Firing an alias is the same as
+	// firing the event, including the callbacks called and the event name
+	// passed to them.
+	Aliases []string
+
+	// Action makes the event an action that runs fn without changing the
+	// state: Event calls the before_ callbacks, the action and the after_
+	// callbacks and returns nil, or the error set on the event. The leave_ and
+	// enter_ callbacks are not called. Dst and Choose are ignored and need not
+	// be set.
+	Action Callback
+
+	// ReEnter makes the transition, when its destination is the current state,
+	// leave and re-enter the state like any other transition instead of
+	// returning NoTransitionError, see SetSelfTransitionsAreReal. Calling
+	// Cancel in the leave_ callbacks still aborts it.
+	ReEnter bool
+
+	// Meta is optional static data for the transition, such as a description
+	// or a required permission, available to the callbacks with Event.Meta. It
+	// is copied when the FSM is constructed.
+	Meta map[string]interface{}
+
+	// Description is an optional human-readable description of the event,
+	// returned by Description. If an event is defined more than once, the
+	// first non-empty description is used.
+	Description string
+}
+
+// Option configures a FSM when it is constructed.
+type Option func(*FSM)
+
+// WithStrictTransitions makes it an error to define an event more than once
+// from the same source state without guards and with different destinations.
+// See ConflictingTransitionError.
+func WithStrictTransitions() Option {
+	return func(f *FSM) {
+		f.strictTransitions = true
+	}
+}
+
+// WithTransitioner sets the Transitioner used to complete state changes instead
+// of DefaultTransitioner.
+func WithTransitioner(t Transitioner) Option {
+	return func(f *FSM) {
+		f.transitionerObj = t
+	}
+}
+
+// WithKeyNormalizer normalizes the state and event names with fn, for example
+// to make them case insensitive. It is applied to the initial state, the
+// events, aliases and states of the transitions and the callback names when
+// the FSM is constructed, and to the events and states passed to Event, Can,
+// OnEnter, SetState and all the other methods taking an event or a state.
+// States are stored normalized, so Current returns the normalized name.
+func WithKeyNormalizer(fn func(string) string) Option {
+	return func(f *FSM) {
+		f.normalizer = fn
+	}
 }
 
 // Callback is a function type that callbacks should use. Event is the current
 // event info as the callback happens.
 type Callback func(*Event)
 
+// Logger is the interface for logging the phases of the events. See
+// FSM.SetLogger.
+type Logger interface {
+	Logf(format string, args ...interface{})
+}
+
+// Observer is a function type called after every completed transition with the
+// source and destination states, the event and the duration of the transition.
+type Observer func(from, to, event string, d time.Duration)
+
+// ErrorObserver is a function type called when an event fails, with the event,
+// the state it was fired in and the error.
+type ErrorObserver func(event, src string, err error)
+
+// RejectHandler is a function type called when an event is rejected, with the
+// event, the state it was fired in and the reason.
+type RejectHandler func(event, state string, reason error)
+
 // Events is a shorthand for defining the transition map in NewFSM.
 type Events []EventDesc
 
@@ -123,26 +379,116 @@ type Callbacks map[string]Callback
 // which version of the callback will end up in the internal map. This is due
 // to the psuedo random nature of Go maps. No checking for multiple keys is
 // currently performed.
-func NewFSM(initial string, events []EventDesc, callbacks map[string]Callback) *FSM {
+//
+// Options are applied before the transitions and callbacks are mapped. NewFSM
+// panics if the options make the definition invalid, use NewFSMWithError to
+// handle such errors.
+func NewFSM(initial string, events []EventDesc, callbacks map[string]Callback, opts ...Option) *FSM {
+	f, err := newFSM(initial, events, callbacks, opts)
+	if err != nil {
+		panic(err)
+	}
+	return f
+}
+
+// NewFSMWithError constructs a FSM like NewFSM, but returns an error instead of
+// silently accepting an invalid definition. It returns:
+//
+// - EmptyStateError if the initial state or a source or destination state is
+// empty
+//
+// - EmptyEventError if an event name is empty
+//
+// - ConflictingTransitionError for conflicting transitions, as with
+// WithStrictTransitions
+func NewFSMWithError(initial string, events []EventDesc, callbacks map[string]Callback, opts ...Option) (*FSM, error) {
+	if err := checkNames(initial, events); err != nil {
+		return nil, err
+	}
+	return newFSM(initial, events, callbacks, append(opts, WithStrictTransitions()))
+}
+
+// checkNames returns EmptyStateError or EmptyEventError if the initial state
+// or an event or state name of the events is empty.
+func checkNames(initial string, events []EventDesc) error {
+	if initial == "" {
+		return EmptyStateError{}
+	}
+	for _, e := range events {
+		if e.Name == "" {
+			return EmptyEventError{}
+		}
+		if e.Dst == "" && e.Choose == nil && e.Action == nil {
+			return EmptyStateError{e.Name}
+		}
+		for _, src := range e.Src {
+			if src == "" {
+				return EmptyStateError{e.Name}
+			}
+		}
+	}
+	return nil
+}
+
+// newFSM constructs a FSM for NewFSM and NewFSMWithError.
+func newFSM(initial string, events []EventDesc, callbacks map[string]Callback, opts []Option) (*FSM, error) {
 	f := &FSM{
 		transitionerObj: &transitionerStruct{},
+		initial:         initial,
 		current:         initial,
-		transitions:     make(map[eKey]string),
+		enteredAt:       time.Now(),
+		transitions:     make(map[eKey][]eDst),
 		callbacks:       make(map[cKey]Callback),
 	}
+	f.currentValue.Store(initial)
+
+	for _, opt := range opts {
+		opt(f)
+	}
+	if f.normalizer != nil {
+		f.initial = f.normalize(initial)
+		f.current = f.initial
+		f.currentValue.Store(f.initial)
+	}
 
 	// Build transition map and store sets of all events and states.
 	allEvents := make(map[string]bool)
 	allStates := make(map[string]bool)
 	for _, e := range events {
+		e = f.normalizeDesc(e)
+		meta := copyMeta(e.Meta)
+		d := eDst{dst: e.Dst, guard: e.Guard, choose: e.Choose, reEnter: e.ReEnter, meta: meta}
+		if e.Action != nil {
+			d = eDst{guard: e.Guard, action: e.Action, meta: meta}
+		}
 		for _, src := range e.Src {
-			f.transitions[eKey{e.Name, src}] = e.Dst
-			allStates[src] = true
-			allStates[e.Dst] = true
+			key := eKey{e.Name, src}
+			f.transitions[key] = append(f.transitions[key], d)
+			if src != AnyState {
+				allStates[src] = true
+			}
+			if d.dst != "" {
+				allStates[d.dst] = true
+			}
 		}
 		allEvents[e.Name] = true
+		if e.Description != "" && f.descriptions[e.Name] == "" {
+			if f.descriptions == nil {
+				f.descriptions = make(map[string]string)
+			}
+			f.descriptions[e.Name] = e.Description
+		}
+		for _, alias := range e.Aliases {
+			if f.aliases == nil {
+				f.aliases = make(map[string]string)
+			}
+			f.aliases[alias] = e.Name
+		}
 	}
 
+	f.states = allStates
+	f.available = f.availableEvents()
+
 	// Map all callbacks to events/states.
 	for name, fn := range callbacks {
 		var target string
@@ -151,6 +497,9 @@ func NewFSM(initial string, events []EventDesc, callbacks map[string]Callback) *
 		switch {
 		case strings.HasPrefix(name, "before_"):
 			target = strings.TrimPrefix(name, "before_")
+			if target != "event" && target != "state" {
+				target = f.normalize(target)
+			}
 			if target == "event" {
 				target = ""
 				callbackType = callbackBeforeEvent
@@ -159,6 +508,9 @@ func NewFSM(initial string, events []EventDesc, callbacks map[string]Callback) *
 			}
 		case strings.HasPrefix(name, "leave_"):
 			target = strings.TrimPrefix(name, "leave_")
+			if target != "event" && target != "state" {
+				target = f.normalize(target)
+			}
 			if target == "state" {
 				target = ""
 				callbackType = callbackLeaveState
@@ -167,6 +519,9 @@ func NewFSM(initial string, events []EventDesc, callbacks map[string]Callback) *
 			}
 		case strings.HasPrefix(name, "enter_"):
 			target = strings.TrimPrefix(name, "enter_")
+			if target != "event" && target != "state" {
+				target = f.normalize(target)
+			}
 			if target == "state" {
 				target = ""
 				callbackType = callbackEnterState
@@ -175,6 +530,9 @@ func NewFSM(initial string, events []EventDesc, callbacks map[string]Callback) *
 			}
 		case strings.HasPrefix(name, "after_"):
 			target = strings.TrimPrefix(name, "after_")
+			if target != "event" && target != "state" {
+				target = f.normalize(target)
+			}
 			if target == "event" {
 				target = ""
 				callbackType = callbackAfterEvent
@@ -182,7 +540,7 @@ func NewFSM(initial string, events []EventDesc, callbacks map[string]Callback) *
 				callbackType = callbackAfterEvent
 			}
 		default:
-			target = name
+			target = f.normalize(name)
 			if _, ok := allStates[target]; ok {
 				callbackType = callbackEnterState
 			} else if _, ok := allEvents[target]; ok {
@@ -195,253 +553,1832 @@ func NewFSM(initial string, events []EventDesc, callbacks map[string]Callback) *
 		}
 	}
 
-	return f
-}
-
-// Current returns the current state of the FSM.
-func (f *FSM) Current() string {
-	f.stateMu.RLock()
-	defer f.stateMu.RUnlock()
-	return f.current
-}
-
-// Is returns true if state is the current state.
-func (f *FSM) Is(state string) bool {
-	f.stateMu.RLock()
-	defer f.stateMu.RUnlock()
-	return state == f.current
-}
-
-// SetState allows the user to move to the given state from current state.
-// The call does not trigger any callbacks, if defined.
-func (f *FSM) SetState(state string) {
-	f.stateMu.Lock()
-	defer f.stateMu.Unlock()
-	f.current = state
-	return
-}
-
-// Can returns true if event can occur in the current state.
-func (f *FSM) Can(event string) bool {
-	f.stateMu.RLock()
-	defer f.stateMu.RUnlock()
-	_, ok := f.transitions[eKey{event, f.current}]
-	return ok && (f.transition == nil)
-}
-
-// AvailableTransitions returns a list of transitions avilable in the
-// current state.
-func (f *FSM) AvailableTransitions() []string {
-	f.stateMu.RLock()
-	defer f.stateMu.RUnlock()
-	var transitions []string
-	for key := range f.transitions {
-		if key.src == f.current {
-			transitions = append(transitions, key.event)
+	if f.strictTransitions {
+		if errs := f.conflicts(); len(errs) > 0 {
+			return nil, errs[0]
 		}
 	}
-	return transitions
-}
 
-// Cannot returns true if event can not occure in the current state.
-// It is a convenience method to help code read nicely.
-func (f *FSM) Cannot(event string) bool {
-	return !f.Can(event)
+	return f, nil
 }
 
-// Event initiates a state transition with the named event.
-//
-// The call takes a variable number of arguments that will be passed to the
-// callback, if defined.
-//
-// It will return nil if the state change is ok or one of these errors:
-//
-// - event X inappropriate because previous transition did not complete
-//
-// - event X inappropriate in current state Y
-//
-// - event X does not exist
-//
-// - internal error on state transition
-//
-// The last error should never occur in this situation and is a sign of an
-// internal bug.
-func (f *FSM) Event(event string, args ...interface{}) error {
+// Clone returns a new FSM in the initial state with the same transitions,
+// callbacks and settings, including the observers, handlers, logger, final
+// states, timeouts and disabled events. The history and metrics are not
+// copied. The clone does not share any state with the original, but the
+// callbacks are the same functions and will still refer to anything they have
+// captured. It must not be called from within a callback.
+func (f *FSM) Clone() *FSM {
 	f.eventMu.Lock()
 	defer f.eventMu.Unlock()
 
-	f.stateMu.RLock()
-	defer f.stateMu.RUnlock()
-
-	if f.transition != nil {
-		return InTransitionError{event}
+	c := &FSM{
+		transitionerObj:     f.transitionerObj,
+		normalizer:          f.normalizer,
+		initial:             f.initial,
+		current:             f.initial,
+		enteredAt:           time.Now(),
+		transitions:         make(map[eKey][]eDst, len(f.transitions)),
+		states:              make(map[string]bool, len(f.states)),
+		callbacks:           make(map[cKey]Callback, len(f.callbacks)),
+		recoverPanics:       f.recoverPanics,
+		rollbackOnError:     f.rollbackOnError,
+		callbackTimeout:     f.callbackTimeout,
+		selfTransitions:     f.selfTransitions,
+		suggestAlternatives: f.suggestAlternatives,
+		logger:              f.logger,
+		observer:            f.observer,
+		unknownEventHandler: f.unknownEventHandler,
+		errorObserver:       f.errorObserver,
+		observeNoTransition: f.observeNoTransition,
+		rejectHandler:       f.rejectHandler,
+		strictTransitions:   f.strictTransitions,
+	}
+	c.currentValue.Store(c.initial)
+	for k, v := range f.transitions {
+		c.transitions[k] = append([]eDst(nil), v...)
+	}
+	for k, v := range f.states {
+		c.states[k] = v
+	}
+	c.available = c.availableEvents()
+	if f.aliases != nil {
+		c.aliases = make(map[string]string, len(f.aliases))
+		for k, v := range f.aliases {
+			c.aliases[k] = v
+		}
+	}
+	if f.descriptions != nil {
+		c.descriptions = make(map[string]string, len(f.descriptions))
+		for k, v := range f.descriptions {
+			c.descriptions[k] = v
+		}
+	}
+	for k, v := range f.callbacks {
+		c.callbacks[k] = v
+	}
+	if f.hooks != nil {
+		c.hooks = make(map[int][]Callback, len(f.hooks))
+		for k, v := range f.hooks {
+			c.hooks[k] = append([]Callback(nil), v...)
+		}
 	}
 
-	dst, ok := f.transitions[eKey{event, f.current}]
-	if !ok {
-		for ekey := range f.transitions {
-			if ekey.event == event {
-				return InvalidEventError{event, f.current}
-			}
+	f.stateMu.RLock()
+	if f.final != nil {
+		c.final = make(map[string]bool, len(f.final))
+		for k, v := range f.final {
+			c.final[k] = v
+		}
+	}
+	if f.timeouts != nil {
+		c.timeouts = make(map[string]stateTimeout, len(f.timeouts))
+		for k, v := range f.timeouts {
+			c.timeouts[k] = v
+		}
+	}
+	if f.disabled != nil {
+		c.disabled = make(map[string]bool, len(f.disabled))
+		for k, v := range f.disabled {
+			c.disabled[k] = v
 		}
-		return UnknownEventError{event}
 	}
+	f.stateMu.RUnlock()
 
-	e := &Event{f, event, f.current, dst, nil, args, false, false}
+	f.queueMu.Lock()
+	c.queueEnabled = f.queueEnabled
+	c.maxDepth = f.maxDepth
+	f.queueMu.Unlock()
 
-	err := f.beforeEventCallbacks(e)
-	if err != nil {
-		return err
-	}
+	return c
+}
 
-	if f.current == dst {
-		f.afterEventCallbacks(e)
-		return NoTransitionError{e.Err}
-	}
+// OnBeforeEvent sets the callback called before event, the same as a
+// before_<EVENT> callback passed to NewFSM. It replaces any previous callback
+// for the event and must not be called from within a callback.
+func (f *FSM) OnBeforeEvent(event string, fn Callback) {
+	f.setCallbacks([]string{event}, callbackBeforeEvent, fn)
+}
 
-	// Setup the transition, call it later.
-	f.transition = func() {
-		f.stateMu.Lock()
-		f.current = dst
-		f.stateMu.Unlock()
+// OnLeave sets the callback called before leaving state, the same as a
+// leave_<STATE> callback passed to NewFSM. It replaces any previous callback
+// for the state and must not be called from within a callback.
+//
+// With AnyState as state, the callback is called before leaving every state,
+// after the leave_<STATE> callback and before the leave_state callback.
+func (f *FSM) OnLeave(state string, fn Callback) {
+	f.setCallbacks([]string{state}, callbackLeaveState, fn)
+}
 
-		f.enterStateCallbacks(e)
-		f.afterEventCallbacks(e)
-	}
+// OnEnter sets the callback called after entering state, the same as an
+// enter_<STATE> callback passed to NewFSM. It replaces any previous callback
+// for the state and must not be called from within a callback.
+//
+// With AnyState as state, the callback is called after entering every state,
+// after the enter_<STATE> callback and before the enter_state callback.
+func (f *FSM) OnEnter(state string, fn Callback) {
+	f.setCallbacks([]string{state}, callbackEnterState, fn)
+}
 
-	if err = f.leaveStateCallbacks(e); err != nil {
-		if _, ok := err.(CanceledError); ok {
-			f.transition = nil
-		}
-		return err
-	}
+// OnEnterAny sets the same callback, like OnEnter, for each of the states.
+// Duplicate states are ignored. Like any enter_<STATE> callback, it is called
+// before the enter_state callback and the callbacks added with AddEnterState.
+func (f *FSM) OnEnterAny(states []string, fn Callback) {
+	f.setCallbacks(states, callbackEnterState, fn)
+}
 
-	// Perform the rest of the transition, if not asynchronous.
-	f.stateMu.RUnlock()
-	err = f.doTransition()
-	f.stateMu.RLock()
-	if err != nil {
-		return InternalError{}
-	}
+// OnLeaveAny sets the same callback, like OnLeave, for each of the states.
+// Duplicate states are ignored. Like any leave_<STATE> callback, it is called
+// before the leave_state callback and the callbacks added with AddLeaveState.
+func (f *FSM) OnLeaveAny(states []string, fn Callback) {
+	f.setCallbacks(states, callbackLeaveState, fn)
+}
 
-	return e.Err
+// OnAfterEvent sets the callback called after event, the same as an
+// after_<EVENT> callback passed to NewFSM. It replaces any previous callback
+// for the event and must not be called from within a callback.
+func (f *FSM) OnAfterEvent(event string, fn Callback) {
+	f.setCallbacks([]string{event}, callbackAfterEvent, fn)
 }
 
-// Transition wraps transitioner.transition.
-func (f *FSM) Transition() error {
+// setCallbacks sets the same callback of a type for several targets.
+func (f *FSM) setCallbacks(targets []string, callbackType int, fn Callback) {
 	f.eventMu.Lock()
 	defer f.eventMu.Unlock()
-	return f.doTransition()
+	f.stateMu.Lock()
+	defer f.stateMu.Unlock()
+	for _, target := range targets {
+		f.callbacks[cKey{f.normalize(target), callbackType}] = fn
+	}
 }
 
-// doTransition wraps transitioner.transition.
-func (f *FSM) doTransition() error {
-	return f.transitionerObj.transition(f)
+// AddBeforeEvent adds a callback called before all events, after the
+// before_<EVENT> and before_event callbacks and any previously added ones. If
+// a callback cancels the event, the remaining callbacks are not called. It
+// must not be called from within a callback.
+func (f *FSM) AddBeforeEvent(fn Callback) {
+	f.addHook(callbackBeforeEvent, fn)
 }
 
-// transitionerStruct is the default implementation of the transitioner
-// interface. Other implementations can be swapped in for testing.
-type transitionerStruct struct{}
+// AddLeaveState adds a callback called before leaving all states, after the
+// leave_<STATE> and leave_state callbacks and any previously added ones. If a
+// callback cancels the event or starts an asynchronous transition, the
+// remaining callbacks are not called. It must not be called from within a
+// callback.
+func (f *FSM) AddLeaveState(fn Callback) {
+	f.addHook(callbackLeaveState, fn)
+}
 
-// Transition completes an asynchrounous state change.
-//
-// The callback for leave_<STATE> must prviously have called Async on its
-// event to have initiated an asynchronous state transition.
-func (t transitionerStruct) transition(f *FSM) error {
-	if f.transition == nil {
-		return NotInTransitionError{}
-	}
-	f.transition()
-	f.transition = nil
-	return nil
+// AddEnterState adds a callback called after entering all states, after the
+// enter_<STATE> and enter_state callbacks and any previously added ones. It
+// must not be called from within a callback.
+func (f *FSM) AddEnterState(fn Callback) {
+	f.addHook(callbackEnterState, fn)
 }
 
-// beforeEventCallbacks calls the before_ callbacks, first the named then the
-// general version.
-func (f *FSM) beforeEventCallbacks(e *Event) error {
-	if fn, ok := f.callbacks[cKey{e.Event, callbackBeforeEvent}]; ok {
-		fn(e)
-		if e.canceled {
-			return CanceledError{e.Err}
-		}
-	}
-	if fn, ok := f.callbacks[cKey{"", callbackBeforeEvent}]; ok {
-		fn(e)
-		if e.canceled {
-			return CanceledError{e.Err}
-		}
-	}
-	return nil
+// AddAfterEvent adds a callback called after all events, after the
+// after_<EVENT> and after_event callbacks and any previously added ones. It
+// must not be called from within a callback.
+func (f *FSM) AddAfterEvent(fn Callback) {
+	f.addHook(callbackAfterEvent, fn)
 }
 
-// leaveStateCallbacks calls the leave_ callbacks, first the named then the
-// general version.
-func (f *FSM) leaveStateCallbacks(e *Event) error {
-	if fn, ok := f.callbacks[cKey{f.current, callbackLeaveState}]; ok {
-		fn(e)
-		if e.canceled {
-			return CanceledError{e.Err}
-		} else if e.async {
-			return AsyncError{e.Err}
+// Link fires event on target whenever the FSM enters state, after the
+// enter_<STATE> and enter_state callbacks, to drive one FSM from another. If
+// the target event fails and propagate is true, the error is set on the event
+// entering state, unless it already has one, and is returned by Event.
+// Otherwise the error is only logged, if a logger is set.
+//
+// The target must not be the FSM itself unless the event queue is enabled.
+func (f *FSM) Link(state string, target *FSM, event string, propagate bool) {
+	state = f.normalize(state)
+	f.AddEnterState(func(e *Event) {
+		if e.Dst != state {
+			return
 		}
-	}
-	if fn, ok := f.callbacks[cKey{"", callbackLeaveState}]; ok {
-		fn(e)
-		if e.canceled {
-			return CanceledError{e.Err}
-		} else if e.async {
-			return AsyncError{e.Err}
+		err := target.Event(event)
+		if err == nil {
+			return
 		}
-	}
-	return nil
+		if propagate {
+			if e.Err == nil {
+				e.Err = err
+			}
+		} else if f.logger != nil {
+			f.logger.Logf("fsm: linked event %s failed: %v", event, err)
+		}
+	})
 }
 
-// enterStateCallbacks calls the enter_ callbacks, first the named then the
-// general version.
-func (f *FSM) enterStateCallbacks(e *Event) {
-	if fn, ok := f.callbacks[cKey{f.current, callbackEnterState}]; ok {
-		fn(e)
-	}
-	if fn, ok := f.callbacks[cKey{"", callbackEnterState}]; ok {
-		fn(e)
+// addHook adds a general callback of a type.
+func (f *FSM) addHook(callbackType int, fn Callback) {
+	f.eventMu.Lock()
+	defer f.eventMu.Unlock()
+	f.stateMu.Lock()
+	defer f.stateMu.Unlock()
+	if f.hooks == nil {
+		f.hooks = make(map[int][]Callback)
 	}
+	f.hooks[callbackType] = append(f.hooks[callbackType], fn)
 }
 
-// afterEventCallbacks calls the after_ callbacks, first the named then the
-// general version.
-func (f *FSM) afterEventCallbacks(e *Event) {
-	if fn, ok := f.callbacks[cKey{e.Event, callbackAfterEvent}]; ok {
-		fn(e)
-	}
-	if fn, ok := f.callbacks[cKey{"", callbackAfterEvent}]; ok {
-		fn(e)
-	}
+// HasBeforeCallback returns true if a before_<EVENT> callback is registered
+// for event, or a before_event callback if event is empty.
+func (f *FSM) HasBeforeCallback(event string) bool {
+	return f.hasCallback(event, callbackBeforeEvent)
 }
 
-const (
-	callbackNone int = iota
-	callbackBeforeEvent
-	callbackLeaveState
-	callbackEnterState
-	callbackAfterEvent
-)
+// HasLeaveCallback returns true if a leave_<STATE> callback is registered for
+// state, or a leave_state callback if state is empty.
+func (f *FSM) HasLeaveCallback(state string) bool {
+	return f.hasCallback(state, callbackLeaveState)
+}
 
-// cKey is a struct key used for keeping the callbacks mapped to a target.
-type cKey struct {
-	// target is either the name of a state or an event depending on which
-	// callback type the key refers to. It can also be "" for a non-targeted
-	// callback like before_event.
-	target string
+// HasEnterCallback returns true if an enter_<STATE> callback is registered for
+// state, or an enter_state callback if state is empty.
+func (f *FSM) HasEnterCallback(state string) bool {
+	return f.hasCallback(state, callbackEnterState)
+}
 
-	// callbackType is the situation when the callback will be run.
-	callbackType int
+// HasAfterCallback returns true if an after_<EVENT> callback is registered for
+// event, or an after_event callback if event is empty.
+func (f *FSM) HasAfterCallback(event string) bool {
+	return f.hasCallback(event, callbackAfterEvent)
 }
 
-// eKey is a struct key used for storing the transition map.
-type eKey struct {
-	// event is the name of the event that the keys refers to.
-	event string
+// hasCallback returns true if a callback is registered for the target and
+// callback type.
+func (f *FSM) hasCallback(target string, callbackType int) bool {
+	f.stateMu.RLock()
+	defer f.stateMu.RUnlock()
+	_, ok := f.callbacks[cKey{f.normalize(target), callbackType}]
+	return ok
+}
 
-	// src is the source from where the event can transition.
-	src string
+// Current returns the current state of the FSM. It does not lock and is safe
+// to call frequently from other goroutines.
+func (f *FSM) Current() string {
+	current, _ := f.currentValue.Load().(string)
+	return current
+}
+
+// initialState returns the initial state, which GobDecode can replace.
+func (f *FSM) initialState() string {
+	f.stateMu.RLock()
+	defer f.stateMu.RUnlock()
+	return f.initial
+}
+
+// Is returns true if state is the current state.
+func (f *FSM) Is(state string) bool {
+	return f.normalize(state) == f.Current()
+}
+
+// SetState allows the user to move to the given state from current state.
+// The call does not trigger any callbacks, if defined. Any pending
+// asynchronous transition is cleared, so a later Transition returns
+// NotInTransitionError instead of moving to its destination. It waits for any
+// event being fired, unless it is called from within a callback of the event,
+// in which case the state is changed right away.
+func (f *FSM) SetState(state string) {
+	if atomic.LoadInt32(&f.callbacksRunning) == 0 {
+		f.eventMu.Lock()
+		defer f.eventMu.Unlock()
+	}
+	f.stateMu.Lock()
+	defer f.stateMu.Unlock()
+	f.setCurrent(f.normalize(state))
+	f.transition = nil
+	f.pending = nil
+	return
+}
+
+// Reset moves the FSM back to its initial state, clearing any pending
+// asynchronous transition and queued events.
+//
+// If fireCallbacks is true and the state changes, the leave_ callbacks of the
+// current state and the enter_ callbacks of the initial state are called with
+// an event with an empty name. Calling Cancel or Async in the leave_ callbacks
+// has no effect. Reset must not be called from within a callback.
+func (f *FSM) Reset(fireCallbacks bool) {
+	f.eventMu.Lock()
+	defer f.eventMu.Unlock()
+
+	f.queueMu.Lock()
+	f.queue = nil
+	f.queueMu.Unlock()
+
+	f.stateMu.Lock()
+	src := f.current
+	f.setCurrent(f.initial)
+	f.transition = nil
+	f.pending = nil
+	f.stateMu.Unlock()
+
+	if fireCallbacks && src != f.initial {
+		e := &Event{FSM: f, Src: src, Dst: f.initial, ctx: context.Background()}
+		f.leaveStateCallbacks(e)()
+		f.enterStateCallbacks(e)()
+	}
+}
+
+// FireInitialEnter calls the enter_ callbacks of the initial state, which are
+// not called when the FSM is constructed, with an event with an empty name and
+// source state. It returns the error set on the event by the callbacks, if any.
+//
+// The callbacks are called at most once and only while the FSM is still in its
+// initial state; otherwise FireInitialEnter returns nil without calling them.
+// It must not be called from within a callback.
+func (f *FSM) FireInitialEnter() (err error) {
+	f.eventMu.Lock()
+	defer f.eventMu.Unlock()
+	defer f.recoverPanic(&err)
+
+	if f.initialEntered || f.Current() != f.initial {
+		return nil
+	}
+	f.initialEntered = true
+
+	e := &Event{FSM: f, Dst: f.initial, ctx: context.Background()}
+	f.enterStateCallbacks(e)()
+	return e.Err
+}
+
+// Phase is a phase of a transition in which callbacks are called, see
+// RunPhase.
+type Phase int
+
+const (
+	// PhaseBefore calls the before_ callbacks of the event.
+	PhaseBefore Phase = iota
+	// PhaseLeave calls the leave_ callbacks of the source state.
+	PhaseLeave
+	// PhaseEnter calls the enter_ callbacks of the destination state.
+	PhaseEnter
+	// PhaseAfter calls the after_ callbacks of the event.
+	PhaseAfter
+)
+
+// RunPhase calls only the callbacks of one phase, in the same order as Event,
+// with an event built from the event name, source and destination states and
+// arguments, for testing callbacks in isolation. The state of the FSM is not
+// changed and is not checked against the source state.
+//
+// It returns CanceledError if a before_ or leave_ callback cancels the event,
+// AsyncError if a leave_ callback calls Async and otherwise the error set on
+// the event, if any. It must not be called from within a callback.
+func (f *FSM) RunPhase(phase Phase, event, src, dst string, args ...interface{}) (err error) {
+	f.eventMu.Lock()
+	defer f.eventMu.Unlock()
+	defer f.recoverPanic(&err)
+
+	e := &Event{FSM: f, Event: f.canonical(event), Src: f.normalize(src), Dst: f.normalize(dst), Args: args, ctx: context.Background()}
+	switch phase {
+	case PhaseBefore:
+		err = f.beforeEventCallbacks(e)()
+	case PhaseLeave:
+		err = f.leaveStateCallbacks(e)()
+	case PhaseEnter:
+		err = f.enterStateCallbacks(e)()
+	case PhaseAfter:
+		err = f.afterEventCallbacks(e)()
+	}
+	if err != nil {
+		return err
+	}
+	return e.Err
+}
+
+// RestoreState moves to the given state from the current state, like SetState,
+// but returns an UnknownStateError if the state is not used as a source or
+// destination by any transition. The call does not trigger any callbacks, if
+// defined.
+func (f *FSM) RestoreState(state string) error {
+	state = f.normalize(state)
+	f.stateMu.RLock()
+	known := f.states[state]
+	f.stateMu.RUnlock()
+	if !known {
+		return UnknownStateError{state}
+	}
+	f.SetState(state)
+	return nil
+}
+
+// Can returns true if event can occur in the current state. Guards are not
+// evaluated since they depend on the event arguments.
+func (f *FSM) Can(event string) bool {
+	f.stateMu.RLock()
+	defer f.stateMu.RUnlock()
+	_, ok := f.dsts(event, f.current)
+	return ok && (f.transition == nil) && !f.final[f.current] && !f.disabled[f.canonical(event)] && !f.frozen
+}
+
+// Peek returns the destination state that event would transition to from the
+// current state, without firing it. It returns false if the event can not
+// occur, like Can. Guards and Choose functions are not evaluated and the
+// static destination of the first transition defined for the event is
+// returned.
+func (f *FSM) Peek(event string) (string, bool) {
+	f.stateMu.RLock()
+	defer f.stateMu.RUnlock()
+	dsts, ok := f.dsts(event, f.current)
+	if !ok || f.transition != nil || f.final[f.current] || f.disabled[f.canonical(event)] || f.frozen {
+		return "", false
+	}
+	if dsts[0].action != nil {
+		return f.current, true
+	}
+	return dsts[0].dst, true
+}
+
+// AvailableTransitions returns a sorted list of the events available in the
+// current state. No events are available while an asynchronous transition is
+// in progress. Aliases and disabled events are not included.
+func (f *FSM) AvailableTransitions() []string {
+	f.stateMu.RLock()
+	defer f.stateMu.RUnlock()
+	return f.availableTransitions()
+}
+
+// Description returns the description of event, or the event it is an alias
+// for, or an empty string if it has none.
+func (f *FSM) Description(event string) string {
+	return f.descriptions[f.canonical(event)]
+}
+
+// AvailableTransitionsSnapshot returns the current state together with the
+// events available in it, like AvailableTransitions, read at the same time so
+// that the events always belong to the returned state.
+func (f *FSM) AvailableTransitionsSnapshot() (string, []string) {
+	f.stateMu.RLock()
+	defer f.stateMu.RUnlock()
+	return f.current, f.availableTransitions()
+}
+
+// availableTransitions returns the events available in the current state.
+// stateMu must be held.
+func (f *FSM) availableTransitions() []string {
+	var transitions []string
+	if f.transition != nil || f.final[f.current] || f.frozen {
+		return transitions
+	}
+	events, ok := f.available[f.current]
+	if !ok {
+		events = f.available[AnyState]
+	}
+	for _, event := range events {
+		if !f.disabled[event] {
+			transitions = append(transitions, event)
+		}
+	}
+	return transitions
+}
+
+// availableEvents builds the index of the events available in each state used
+// by AvailableTransitions.
+func (f *FSM) availableEvents() map[string][]string {
+	available := make(map[string][]string, len(f.states)+1)
+	for key := range f.transitions {
+		if key.src == AnyState {
+			for state := range f.states {
+				if _, ok := f.transitions[eKey{key.event, state}]; !ok {
+					available[state] = append(available[state], key.event)
+				}
+			}
+		}
+		available[key.src] = append(available[key.src], key.event)
+	}
+	for _, events := range available {
+		sort.Strings(events)
+	}
+	return available
+}
+
+// States returns a sorted list of all states used as source or destination by
+// the transitions.
+func (f *FSM) States() []string {
+	f.stateMu.RLock()
+	defer f.stateMu.RUnlock()
+	return f.sortedStates()
+}
+
+// sortedStates returns the states for States. stateMu must be held.
+func (f *FSM) sortedStates() []string {
+	states := make([]string, 0, len(f.states))
+	for state := range f.states {
+		states = append(states, state)
+	}
+	sort.Strings(states)
+	return states
+}
+
+// Events returns a sorted list of all events defined in the FSM, regardless of
+// the current state. Aliases are not included, see Aliases.
+func (f *FSM) Events() []string {
+	f.stateMu.RLock()
+	defer f.stateMu.RUnlock()
+	seen := make(map[string]bool)
+	var events []string
+	for key := range f.transitions {
+		if !seen[key.event] {
+			seen[key.event] = true
+			events = append(events, key.event)
+		}
+	}
+	sort.Strings(events)
+	return events
+}
+
+// TransitionInfo describes a transition, as returned by TransitionTable.
+type TransitionInfo struct {
+	// Event is the event name.
+	Event string
+
+	// Src is the source state, or AnyState.
+	Src string
+
+	// Dst is the destination state. It is empty if the destination is picked
+	// by a Choose function without a static destination, and the source state
+	// for an action.
+	Dst string
+}
+
+// TransitionTable returns all transitions of the FSM, sorted by source state,
+// event and destination state. Aliases are not included.
+func (f *FSM) TransitionTable() []TransitionInfo {
+	f.stateMu.RLock()
+	defer f.stateMu.RUnlock()
+	var table []TransitionInfo
+	for k, dsts := range f.transitions {
+		for _, d := range dsts {
+			dst := d.dst
+			if d.action != nil {
+				dst = k.src
+			}
+			table = append(table, TransitionInfo{k.event, k.src, dst})
+		}
+	}
+	sort.Slice(table, func(i, j int) bool {
+		if table[i].Src != table[j].Src {
+			return table[i].Src < table[j].Src
+		}
+		if table[i].Event != table[j].Event {
+			return table[i].Event < table[j].Event
+		}
+		return table[i].Dst < table[j].Dst
+	})
+	return table
+}
+
+// IncomingTransitions returns the transitions with state as destination,
+// sorted by source state and event, like TransitionTable. Transitions from
+// AnyState have AnyState as source.
+func (f *FSM) IncomingTransitions(state string) []TransitionInfo {
+	state = f.normalize(state)
+	var incoming []TransitionInfo
+	for _, t := range f.TransitionTable() {
+		if t.Dst == state {
+			incoming = append(incoming, t)
+		}
+	}
+	return incoming
+}
+
+// AddTransition adds a transition from src, or AnyState, to dst for the named
+// event, or the event it is an alias for, to the FSM, after the transitions already defined for the event and
+// source state. It returns EmptyEventError or EmptyStateError for empty names,
+// InTransitionError while an asynchronous transition is pending and, if the
+// FSM was constructed with WithStrictTransitions or NewFSMWithError, a
+// ConflictingTransitionError instead of adding a conflicting transition. It
+// must not be called from within a callback.
+func (f *FSM) AddTransition(event, src, dst string) error {
+	if event == "" {
+		return EmptyEventError{}
+	}
+	if src == "" || dst == "" {
+		return EmptyStateError{event}
+	}
+	event, src, dst = f.canonical(event), f.normalize(src), f.normalize(dst)
+
+	f.eventMu.Lock()
+	defer f.eventMu.Unlock()
+	f.stateMu.Lock()
+	defer f.stateMu.Unlock()
+
+	if f.pending != nil {
+		return InTransitionError{event, f.pending.Src, f.pending.Dst}
+	}
+
+	key := eKey{event, src}
+	dsts := f.transitions[key]
+	f.transitions[key] = append(dsts[:len(dsts):len(dsts)], eDst{dst: dst})
+	if f.strictTransitions {
+		if errs := f.conflicts(); len(errs) > 0 {
+			if dsts == nil {
+				delete(f.transitions, key)
+			} else {
+				f.transitions[key] = dsts
+			}
+			return errs[0]
+		}
+	}
+	f.rebuildIndexes()
+	return nil
+}
+
+// RemoveTransition removes all transitions for the named event from src, or
+// AnyState. It returns InvalidEventError if there are none but the event is
+// defined from other states, UnknownEventError if it is not defined at all
+// and InTransitionError while an asynchronous transition is pending. It must
+// not be called from within a callback.
+func (f *FSM) RemoveTransition(event, src string) error {
+	event, src = f.canonical(event), f.normalize(src)
+
+	f.eventMu.Lock()
+	defer f.eventMu.Unlock()
+	f.stateMu.Lock()
+	defer f.stateMu.Unlock()
+
+	if f.pending != nil {
+		return InTransitionError{event, f.pending.Src, f.pending.Dst}
+	}
+
+	key := eKey{event, src}
+	if _, ok := f.transitions[key]; !ok {
+		for k := range f.transitions {
+			if k.event == event {
+				return InvalidEventError{Event: event, State: src}
+			}
+		}
+		return UnknownEventError{event}
+	}
+	delete(f.transitions, key)
+	f.rebuildIndexes()
+	return nil
+}
+
+// rebuildIndexes rebuilds the set of states and the index of available events
+// after the transitions have changed. stateMu must be held.
+func (f *FSM) rebuildIndexes() {
+	states := make(map[string]bool)
+	for k, dsts := range f.transitions {
+		if k.src != AnyState {
+			states[k.src] = true
+		}
+		for _, d := range dsts {
+			if d.dst != "" {
+				states[d.dst] = true
+			}
+		}
+	}
+	f.states = states
+	f.available = f.availableEvents()
+}
+
+// InTransition returns true if an asynchronous transition is pending, waiting
+// for a call to Transition.
+func (f *FSM) InTransition() bool {
+	f.stateMu.RLock()
+	defer f.stateMu.RUnlock()
+	return f.transition != nil
+}
+
+// Aliases returns a sorted list of the aliases of event.
+func (f *FSM) Aliases(event string) []string {
+	event = f.normalize(event)
+	var aliases []string
+	for alias, name := range f.aliases {
+		if name == event {
+			aliases = append(aliases, alias)
+		}
+	}
+	sort.Strings(aliases)
+	return aliases
+}
+
+// SetFinalStates marks states as final, replacing any previously marked final
+// states. No events can occur in a final state, Event returns
+// TerminalStateError instead.
+func (f *FSM) SetFinalStates(states ...string) {
+	f.stateMu.Lock()
+	defer f.stateMu.Unlock()
+	f.final = make(map[string]bool, len(states))
+	for _, state := range states {
+		f.final[f.normalize(state)] = true
+	}
+}
+
+// DisableEvent forbids event, or the event it is an alias for, until it is
+// enabled again with EnableEvent. Event returns DisabledEventError for a
+// disabled event, and Can and AvailableTransitions treat it as unavailable.
+func (f *FSM) DisableEvent(event string) {
+	f.stateMu.Lock()
+	defer f.stateMu.Unlock()
+	if f.disabled == nil {
+		f.disabled = make(map[string]bool)
+	}
+	f.disabled[f.canonical(event)] = true
+}
+
+// EnableEvent allows event again after DisableEvent.
+func (f *FSM) EnableEvent(event string) {
+	f.stateMu.Lock()
+	defer f.stateMu.Unlock()
+	delete(f.disabled, f.canonical(event))
+}
+
+// Freeze makes the FSM reject all events, and the completion of a pending
+// asynchronous transition, with FrozenError until Unfreeze is called. The
+// state is not changed. Can returns false and AvailableTransitions returns no
+// events while frozen.
+func (f *FSM) Freeze() {
+	f.stateMu.Lock()
+	defer f.stateMu.Unlock()
+	f.frozen = true
+}
+
+// Unfreeze accepts events again after Freeze.
+func (f *FSM) Unfreeze() {
+	f.stateMu.Lock()
+	defer f.stateMu.Unlock()
+	f.frozen = false
+}
+
+// IsFinal returns true if the current state is a final state.
+func (f *FSM) IsFinal() bool {
+	f.stateMu.RLock()
+	defer f.stateMu.RUnlock()
+	return f.final[f.current]
+}
+
+// IsStuck returns true if no transitions are defined from the current state,
+// including from AnyState, and it is not a final state. Unlike Validate, it
+// detects a dead end at runtime.
+func (f *FSM) IsStuck() bool {
+	f.stateMu.RLock()
+	defer f.stateMu.RUnlock()
+	if f.final[f.current] {
+		return false
+	}
+	for key := range f.transitions {
+		if key.src == f.current || key.src == AnyState {
+			return false
+		}
+	}
+	return true
+}
+
+// CanFireAny returns true if any event can occur in the current state, that is
+// if AvailableTransitions is not empty. Guards are not evaluated.
+func (f *FSM) CanFireAny() bool {
+	return len(f.AvailableTransitions()) > 0
+}
+
+// Cannot returns true if event can not occure in the current state.
+// It is a convenience method to help code read nicely.
+func (f *FSM) Cannot(event string) bool {
+	return !f.Can(event)
+}
+
+// Event initiates a state transition with the named event.
+//
+// The call takes a variable number of arguments that will be passed to the
+// callback, if defined.
+//
+// It will return nil if the state change is ok or one of these errors:
+//
+// - event X inappropriate because previous transition did not complete
+//
+// - event X inappropriate in current state Y
+//
+// - event X guard failed in current state Y
+//
+// - event X does not exist
+//
+// - internal error on state transition
+//
+// The last error should never occur in this situation and is a sign of an
+// internal bug.
+func (f *FSM) Event(event string, args ...interface{}) error {
+	return f.EventWithContext(context.Background(), event, args...)
+}
+
+// Outcome is what happened when an event was fired with EventResult.
+type Outcome int
+
+const (
+	// OutcomeTransitioned means the event completed. The state has changed,
+	// unless the event was an action or a self-transition made real with
+	// SetSelfTransitionsAreReal or ReEnter. An event queued with
+	// SetEventQueue also gives this outcome.
+	OutcomeTransitioned Outcome = iota
+	// OutcomeNoChange means the destination was the current state and only
+	// the before_ and after_ callbacks were called.
+	OutcomeNoChange
+	// OutcomeAsyncPending means an asynchronous transition was started and
+	// waits for Transition to be called.
+	OutcomeAsyncPending
+	// OutcomeRejected means the event failed and the state is unchanged,
+	// unless an enter_ or after_ callback failed.
+	OutcomeRejected
+)
+
+// EventResult fires the event like Event and returns what happened. Unlike
+// Event it does not treat NoTransitionError and AsyncError as errors, returning
+// OutcomeNoChange and OutcomeAsyncPending with the error set by the callbacks,
+// if any. All other errors give OutcomeRejected.
+func (f *FSM) EventResult(event string, args ...interface{}) (Outcome, error) {
+	err := f.Event(event, args...)
+	switch err := err.(type) {
+	case nil:
+		return OutcomeTransitioned, nil
+	case NoTransitionError:
+		return OutcomeNoChange, err.Err
+	case AsyncError:
+		return OutcomeAsyncPending, err.Err
+	default:
+		return OutcomeRejected, err
+	}
+}
+
+// MustEvent is like Event but panics with the error, if any, instead of
+// returning it. Note that NoTransitionError and AsyncError are errors too. It
+// is intended for tests and simple programs.
+func (f *FSM) MustEvent(event string, args ...interface{}) {
+	if err := f.Event(event, args...); err != nil {
+		panic(err)
+	}
+}
+
+// EventWithPayload initiates a state transition with the named event like
+// Event, with a single payload instead of arguments, available to the
+// callbacks with Event.Payload.
+func (f *FSM) EventWithPayload(event string, payload interface{}) error {
+	return f.eventWithPayload(context.Background(), event, payload, nil)
+}
+
+// EventWithContext initiates a state transition with the named event, the same
+// way as Event. The context is available to the callbacks through
+// Event.Context.
+//
+// If the context is done before the transition has started, or during the
+// before_ and leave_ callbacks, the transition is aborted without changing the
+// state and the context's error is returned.
+func (f *FSM) EventWithContext(ctx context.Context, event string, args ...interface{}) error {
+	return f.eventWithPayload(ctx, event, nil, args)
+}
+
+// eventWithPayload fires the event for EventWithContext and EventWithPayload.
+func (f *FSM) eventWithPayload(ctx context.Context, event string, payload interface{}, args []interface{}) error {
+	if queued, err := f.enqueue(ctx, event, payload, args); queued {
+		return err
+	}
+	return f.processQueue(true, func() error {
+		return f.lockedEvent(ctx, event, payload, args...)
+	})
+}
+
+// EventIf fires event like Event, but only if the FSM is in the expected state
+// when the event is fired, checked atomically with firing it. It returns an
+// UnexpectedStateError otherwise. Unlike Event, it is never queued and must
+// not be called from within a callback.
+func (f *FSM) EventIf(expected string, event string, args ...interface{}) error {
+	return f.processQueue(f.startProcessing(), func() error {
+		return f.eventIf(expected, event, args)
+	})
+}
+
+// eventIf fires the event for EventIf with eventMu held.
+func (f *FSM) eventIf(expected string, event string, args []interface{}) error {
+	f.eventMu.Lock()
+	defer f.eventMu.Unlock()
+
+	expected = f.normalize(expected)
+	f.stateMu.RLock()
+	current := f.current
+	f.stateMu.RUnlock()
+	if current != expected {
+		return UnexpectedStateError{expected, current}
+	}
+	return f.fireNext(f.event(context.Background(), event, nil, args...))
+}
+
+// joinAlternatives joins the events suggested by InvalidEventError, with their
+// descriptions, if any, such as "kick, lock (lock the door) or open".
+func (f *FSM) joinAlternatives(events []string) string {
+	names := make([]string, len(events))
+	for i, event := range events {
+		names[i] = event
+		if desc := f.descriptions[event]; desc != "" {
+			names[i] += " (" + desc + ")"
+		}
+	}
+	if n := len(names); n > 1 {
+		return strings.Join(names[:n-1], ", ") + " or " + names[n-1]
+	}
+	return strings.Join(names, "")
+}
+
+// lockedEvent calls event with eventMu held.
+func (f *FSM) lockedEvent(ctx context.Context, event string, payload interface{}, args ...interface{}) error {
+	f.eventMu.Lock()
+	defer f.eventMu.Unlock()
+	return f.fireNext(f.event(ctx, event, payload, args...))
+}
+
+// fireNext fires the events scheduled with Event.NextEvent, one after the
+// other, if err from the previous event is nil, and returns the error of the
+// last one. eventMu must be held.
+func (f *FSM) fireNext(err error) error {
+	for err == nil && f.next != nil {
+		next := f.next
+		f.next = nil
+		err = f.event(next.ctx, next.event, next.payload, next.args...)
+	}
+	f.next = nil
+	return err
+}
+
+// event performs the state transition for EventWithContext. eventMu must be
+// held.
+func (f *FSM) event(ctx context.Context, event string, payload interface{}, args ...interface{}) (err error) {
+	start := time.Now()
+
+	f.stateMu.RLock()
+	current := f.current
+	pending := f.pending
+	final := f.final[current]
+	disabled := f.disabled[f.canonical(event)]
+	frozen := f.frozen
+	f.stateMu.RUnlock()
+
+	defer func() {
+		f.observeError(event, current, err)
+	}()
+	defer f.recoverPanic(&err)
+
+	event = f.canonical(event)
+
+	if frozen {
+		return FrozenError{event}
+	}
+
+	if pending != nil {
+		return InTransitionError{event, pending.Src, pending.Dst}
+	}
+
+	if final {
+		return TerminalStateError{event, current}
+	}
+
+	if disabled {
+		return DisabledEventError{event}
+	}
+
+	dsts, ok := f.dsts(event, current)
+	if !ok {
+		for ekey := range f.transitions {
+			if ekey.event == event {
+				var alternatives string
+				if f.suggestAlternatives {
+					f.stateMu.RLock()
+					alternatives = f.joinAlternatives(f.availableTransitions())
+					f.stateMu.RUnlock()
+				}
+				return InvalidEventError{Event: event, State: current, Alternatives: alternatives}
+			}
+		}
+		if f.unknownEventHandler != nil {
+			return f.unknownEventHandler(event, args...)
+		}
+		return UnknownEventError{event}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	e := &Event{FSM: f, Event: event, Src: current, Args: args, ctx: ctx, payload: payload}
+	d, ok := f.resolveDst(e, dsts)
+	if !ok {
+		return GuardFailedError{event, current}
+	}
+	if d.choose != nil {
+		e.Dst = f.normalize(d.choose(e))
+		if !f.states[e.Dst] {
+			return InvalidDestinationError{event, e.Dst}
+		}
+	}
+	dst := e.Dst
+
+	err = f.runPhase("before", e, f.beforeEventCallbacks(e))
+	if err != nil {
+		return err
+	}
+
+	if d.action != nil {
+		logger, after := f.logger, f.afterEventCallbacks(e)
+		err = f.runPhase("action", e, func() error {
+			logPhase(logger, "action", e)
+			d.action(e)
+			return after()
+		})
+		if err != nil {
+			return err
+		}
+		return e.Err
+	}
+
+	if current == dst && !f.selfTransitions && !d.reEnter {
+		err = f.runPhase("after", e, f.afterEventCallbacks(e))
+		if err != nil {
+			return err
+		}
+		return NoTransitionError{e.Err}
+	}
+
+	// Setup the transition, call it later.
+	f.setTransition(e, func() error {
+		f.stateMu.Lock()
+		f.setCurrent(dst)
+		f.stateMu.Unlock()
+
+		// With rollback, an error set before the state change is put aside so
+		// that any error set by the enter_ and after_ callbacks triggers it.
+		err := e.Err
+		if f.rollbackOnError {
+			e.Err = nil
+		}
+		if terr := f.runPhase("enter", e, f.enterStateCallbacks(e)); terr != nil {
+			return terr
+		}
+		if f.rollbackOnError && e.Err != nil {
+			f.rollback(e)
+			return nil
+		}
+		if terr := f.runPhase("after", e, f.afterEventCallbacks(e)); terr != nil {
+			return terr
+		}
+		if f.rollbackOnError {
+			if e.Err != nil {
+				f.rollback(e)
+				return nil
+			}
+			e.Err = err
+		}
+
+		f.stateMu.Lock()
+		f.generation++
+		f.lastTransition = time.Now()
+		f.record(e)
+		f.countVisit(e.Dst)
+		f.publish(e)
+		f.stateMu.Unlock()
+
+		if f.observer != nil {
+			f.observer(e.Src, e.Dst, e.Event, time.Since(start))
+		}
+		if e.Err == nil {
+			f.next = e.next
+		}
+		return nil
+	})
+
+	err = f.runPhase("leave", e, f.leaveStateCallbacks(e))
+	if err != nil {
+		if _, ok := err.(AsyncError); !ok {
+			f.setTransition(nil, nil)
+		}
+		return err
+	}
+
+	// Perform the rest of the transition, if not asynchronous.
+	err = f.doTransition()
+	if _, ok := err.(CallbackTimeoutError); ok {
+		return err
+	} else if err != nil {
+		return InternalError{}
+	}
+
+	return e.Err
+}
+
+// rollback moves the FSM back to the source state of the event after an enter_
+// or after_ callback has set an error.
+func (f *FSM) rollback(e *Event) {
+	f.stateMu.Lock()
+	f.setCurrent(e.Src)
+	f.stateMu.Unlock()
+	e.Err = RollbackError{e.Err}
+}
+
+// setTransition sets the pending transition function and its event.
+func (f *FSM) setTransition(e *Event, transition func() error) {
+	f.stateMu.Lock()
+	defer f.stateMu.Unlock()
+	f.transition = transition
+	f.pending = e
+}
+
+// FireSequence fires the events in order, stopping at the first event that
+// returns an error, including NoTransitionError and AsyncError. The error is
+// returned as a SequenceError with the index of the failed event. Events fired
+// before the failure are not undone.
+func (f *FSM) FireSequence(events ...string) error {
+	for i, event := range events {
+		if err := f.Event(event); err != nil {
+			return SequenceError{i, event, err}
+		}
+	}
+	return nil
+}
+
+// Transition wraps Transitioner.Transition.
+func (f *FSM) Transition() error {
+	return f.TransitionWithArgs()
+}
+
+// TransitionWithArgs completes an asynchronous state change like Transition,
+// appending args to the arguments of the event for the remaining callbacks.
+func (f *FSM) TransitionWithArgs(args ...interface{}) error {
+	return f.completeTransition(nil, args)
+}
+
+// TransitionWithContext completes an asynchronous state change like
+// Transition. For the enter_ and after_ callbacks, ctx replaces the context
+// the event was fired with, which is available to the earlier callbacks, and
+// is returned by Event.Context.
+//
+// If ctx is already done, TransitionWithContext returns ctx.Err() without
+// calling any callbacks and the transition stays pending, to be completed or
+// canceled later.
+func (f *FSM) TransitionWithContext(ctx context.Context) error {
+	return f.completeTransition(ctx, nil)
+}
+
+// completeTransition completes an asynchronous transition with an optional
+// new context and additional arguments, then fires any queued events.
+func (f *FSM) completeTransition(ctx context.Context, args []interface{}) error {
+	return f.processQueue(f.startProcessing(), func() error {
+		return f.transitionWithArgs(ctx, args)
+	})
+}
+
+// transitionWithArgs completes the transition for completeTransition with
+// eventMu held.
+func (f *FSM) transitionWithArgs(ctx context.Context, args []interface{}) (err error) {
+	f.eventMu.Lock()
+	defer f.eventMu.Unlock()
+	defer f.recoverPanic(&err)
+
+	f.stateMu.RLock()
+	frozen, pending := f.frozen, f.pending
+	f.stateMu.RUnlock()
+	if frozen && pending != nil {
+		return FrozenError{pending.Event}
+	}
+
+	if ctx != nil {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		f.stateMu.Lock()
+		if f.pending != nil {
+			f.pending.ctx = ctx
+		}
+		f.stateMu.Unlock()
+	}
+	if len(args) > 0 {
+		f.stateMu.RLock()
+		if f.pending != nil {
+			f.pending.Args = append(f.pending.Args, args...)
+		}
+		f.stateMu.RUnlock()
+	}
+	return f.fireNext(f.doTransition())
+}
+
+// SetRollbackOnError enables or disables rollback of transitions when an enter_
+// or after_ callback sets the error of the event.
+//
+// With rollback enabled, the FSM moves back to the source state as soon as the
+// enter_ or after_ callbacks have set an error, without calling the remaining
+// callbacks, and Event returns a RollbackError wrapping the error. The enter_
+// and leave_ callbacks are not called for the rollback. Callbacks before the
+// state change can already prevent it by calling Cancel on the event. An error
+// set by those earlier callbacks is not seen by the enter_ and after_
+// callbacks and is returned by Event if the transition is not rolled back.
+func (f *FSM) SetRollbackOnError(enabled bool) {
+	f.eventMu.Lock()
+	defer f.eventMu.Unlock()
+	f.rollbackOnError = enabled
+}
+
+// SetCallbackTimeout limits how long the callbacks of each phase of an event
+// may run: before, leave, enter and after. A duration of 0 or less, the
+// default, removes the limit.
+//
+// With a limit the callbacks of each phase run in a separate goroutine. If a
+// phase does not complete in time, Event, or Transition, returns a
+// CallbackTimeoutError without waiting for it. The transition is aborted: the
+// state is unchanged if the timeout happened before the state change, in the
+// before_ or leave_ callbacks, and is the new state if it happened in the
+// enter_ or after_ callbacks. The callbacks can not be stopped and keep
+// running in the background, so they should not rely on the event or the
+// state of the FSM after a timeout and should finish eventually. Panics in the
+// callbacks are raised again in the goroutine that fired the event.
+func (f *FSM) SetCallbackTimeout(d time.Duration) {
+	f.eventMu.Lock()
+	defer f.eventMu.Unlock()
+	f.callbackTimeout = d
+}
+
+// phaseResult is the outcome of a phase of callbacks run by runPhase.
+type phaseResult struct {
+	err      error
+	panicked bool
+	value    interface{}
+}
+
+// runPhase runs the callbacks of a phase, in a separate goroutine limited by
+// the callback timeout if one is set. It must be called with eventMu held.
+func (f *FSM) runPhase(phase string, e *Event, fn func() error) error {
+	if f.callbackTimeout <= 0 {
+		return fn()
+	}
+
+	done := make(chan phaseResult, 1)
+	go func() {
+		var res phaseResult
+		defer func() {
+			if r := recover(); r != nil {
+				res.panicked = true
+				res.value = r
+			}
+			done <- res
+		}()
+		res.err = fn()
+	}()
+
+	timer := time.NewTimer(f.callbackTimeout)
+	defer timer.Stop()
+	select {
+	case res := <-done:
+		if res.panicked {
+			panic(res.value)
+		}
+		return res.err
+	case <-timer.C:
+		logPhase(f.logger, "timeout", e)
+		return CallbackTimeoutError{e.Event, phase}
+	}
+}
+
+// SetSelfTransitionsAreReal sets whether events with the current state as
+// destination are real transitions.
+//
+// By default such an event only calls the before_ and after_ callbacks and
+// Event returns NoTransitionError. When enabled it is handled like any other
+// transition: the leave_ and enter_ callbacks are called, the state is
+// re-entered, restarting its timeout, and Event returns nil.
+func (f *FSM) SetSelfTransitionsAreReal(enabled bool) {
+	f.eventMu.Lock()
+	defer f.eventMu.Unlock()
+	f.selfTransitions = enabled
+}
+
+// SetSuggestAlternatives sets whether the InvalidEventError returned for an
+// event that can not occur in the current state suggests the events available
+// instead, with their descriptions, as in "try lock (lock the door) or open
+// instead". It is disabled by default.
+func (f *FSM) SetSuggestAlternatives(enabled bool) {
+	f.eventMu.Lock()
+	defer f.eventMu.Unlock()
+	f.suggestAlternatives = enabled
+}
+
+// SetLogger sets a logger for the phases of the events: before, leave, enter
+// and after, as well as when an event is canceled or starts an asynchronous
+// transition. A nil logger, the default, disables logging.
+func (f *FSM) SetLogger(logger Logger) {
+	f.eventMu.Lock()
+	defer f.eventMu.Unlock()
+	f.logger = logger
+}
+
+// SetObserver sets a function called after every completed transition, after
+// the after_ callbacks. The duration is the wall-clock time from firing the
+// event, including the time waiting for an asynchronous transition. It is not
+// called for canceled transitions or events without a state change. A nil
+// observer removes it.
+func (f *FSM) SetObserver(observer Observer) {
+	f.eventMu.Lock()
+	defer f.eventMu.Unlock()
+	f.observer = observer
+}
+
+// SetUnknownEventHandler sets a function called by Event for events that are not
+// defined in the FSM, instead of returning UnknownEventError. Its return value
+// is returned by Event. It is not called for events that are defined but
+// invalid in the current state. The handler is called like a callback and must
+// not call Event unless the event queue is enabled. A nil handler removes it.
+func (f *FSM) SetUnknownEventHandler(handler func(event string, args ...interface{}) error) {
+	f.eventMu.Lock()
+	defer f.eventMu.Unlock()
+	f.unknownEventHandler = handler
+}
+
+// SetErrorObserver sets a function called whenever firing an event fails,
+// including events fired from the event queue or by a state timeout. It is not
+// called for AsyncError, which only signals that an asynchronous transition
+// has started, and only called for NoTransitionError if observeNoTransition is
+// true. A nil observer removes it.
+func (f *FSM) SetErrorObserver(observer ErrorObserver, observeNoTransition bool) {
+	f.eventMu.Lock()
+	defer f.eventMu.Unlock()
+	f.errorObserver = observer
+	f.observeNoTransition = observeNoTransition
+}
+
+// OnReject sets a function called when an event is rejected because it is
+// invalid in the current state, with InvalidEventError, or unknown, with
+// UnknownEventError. It is not called for other errors, like callback errors
+// or canceled transitions. It is called before the error observer. A nil
+// handler removes it.
+func (f *FSM) OnReject(handler RejectHandler) {
+	f.eventMu.Lock()
+	defer f.eventMu.Unlock()
+	f.rejectHandler = handler
+}
+
+// observeError calls the reject handler and the error observer, if set and the
+// error is a failure. eventMu must be held.
+func (f *FSM) observeError(event, src string, err error) {
+	if f.rejectHandler != nil {
+		switch err.(type) {
+		case InvalidEventError, UnknownEventError:
+			f.rejectHandler(event, src, err)
+		}
+	}
+	if f.errorObserver == nil || err == nil {
+		return
+	}
+	switch err.(type) {
+	case AsyncError:
+		return
+	case NoTransitionError:
+		if !f.observeNoTransition {
+			return
+		}
+	}
+	f.errorObserver(event, src, err)
+}
+
+// SetRecoverPanics enables or disables recovery from panics in callbacks,
+// guards and Choose functions.
+//
+// With recovery enabled, a panic aborts the transition and Event, or
+// Transition, returns a CallbackPanicError with the recovered value. The state
+// is left unchanged if the panic happened before the state change, in the
+// before_ or leave_ callbacks, and is the new state if it happened in the
+// enter_ or after_ callbacks. Any pending transition is cleared.
+func (f *FSM) SetRecoverPanics(enabled bool) {
+	f.eventMu.Lock()
+	defer f.eventMu.Unlock()
+	f.recoverPanics = enabled
+}
+
+// recoverPanic recovers from a panic, if enabled, clearing any pending
+// transition and setting err to a CallbackPanicError. It must be deferred
+// with eventMu held.
+func (f *FSM) recoverPanic(err *error) {
+	if !f.recoverPanics {
+		return
+	}
+	if r := recover(); r != nil {
+		f.setTransition(nil, nil)
+		*err = CallbackPanicError{r}
+	}
+}
+
+// SetEventQueue enables or disables the event queue.
+//
+// With the queue enabled, events fired while another event is processed or
+// while an asynchronous transition is pending, including events fired from
+// within callbacks, are queued instead of failing with InTransitionError.
+// Event returns nil for a queued event. The queued events are fired in order
+// once the current transition completes, and may then fail if the state has
+// changed; such errors are only reported to the error observer, if set.
+//
+// Disabling the queue discards any queued events.
+func (f *FSM) SetEventQueue(enabled bool) {
+	f.queueMu.Lock()
+	defer f.queueMu.Unlock()
+	f.queueEnabled = enabled
+	if !enabled {
+		f.queue = nil
+	}
+}
+
+// SetMaxTransitionDepth limits how many events can be queued in a chain, each
+// fired while processing the previous one, for example by callbacks that
+// fire events again, to stop infinite loops. An event that would exceed the
+// depth is not queued and Event returns a TransitionDepthExceededError for it.
+// A depth of 0 or less, the default, removes the limit.
+//
+// The depth only applies in queued mode, see SetEventQueue. It is kept by the
+// FSM for the event being processed, so events fired by callbacks count as
+// part of its chain however they are fired. Since events are not tracked per
+// goroutine, events fired by other goroutines while an event is processed also
+// count as part of its chain.
+func (f *FSM) SetMaxTransitionDepth(n int) {
+	f.queueMu.Lock()
+	defer f.queueMu.Unlock()
+	f.maxDepth = n
+}
+
+// enqueue queues the event if the queue is enabled and another event is being
+// processed, or returns TransitionDepthExceededError if that would exceed the
+// maximum depth. Otherwise it marks the FSM as processing, if the queue is
+// enabled, and returns false.
+func (f *FSM) enqueue(ctx context.Context, event string, payload interface{}, args []interface{}) (bool, error) {
+	f.queueMu.Lock()
+	defer f.queueMu.Unlock()
+	if !f.queueEnabled {
+		return false, nil
+	}
+	if f.processing || f.InTransition() {
+		depth := 0
+		if f.processing {
+			depth = f.depth + 1
+		}
+		if f.maxDepth > 0 && depth > f.maxDepth {
+			return true, TransitionDepthExceededError{event, f.maxDepth}
+		}
+		f.queue = append(f.queue, queuedEvent{ctx, event, payload, args, depth})
+		return true, nil
+	}
+	f.processing = true
+	f.depth = 0
+	return false, nil
+}
+
+// startProcessing marks the FSM as processing if the queue is enabled and no
+// other event is being processed. It returns true if the caller should drain
+// the queue when done.
+func (f *FSM) startProcessing() bool {
+	f.queueMu.Lock()
+	defer f.queueMu.Unlock()
+	if !f.queueEnabled || f.processing {
+		return false
+	}
+	f.processing = true
+	f.depth = 0
+	return true
+}
+
+// processQueue calls fn and then, if owner is true because the caller marked
+// the FSM as processing, drains the queue. If fn or a queued event panics, the
+// FSM is no longer marked as processing, so that later events are not queued
+// forever.
+func (f *FSM) processQueue(owner bool, fn func() error) error {
+	if !owner {
+		return fn()
+	}
+	drained := false
+	defer func() {
+		if !drained {
+			f.queueMu.Lock()
+			f.processing = false
+			f.queueMu.Unlock()
+		}
+	}()
+	err := fn()
+	f.drainQueue()
+	drained = true
+	return err
+}
+
+// drainQueue fires the queued events in order until the queue is empty or an
+// asynchronous transition is pending.
+func (f *FSM) drainQueue() {
+	for {
+		f.queueMu.Lock()
+		if len(f.queue) == 0 || f.InTransition() {
+			f.processing = false
+			f.queueMu.Unlock()
+			return
+		}
+		q := f.queue[0]
+		f.queue = f.queue[1:]
+		f.depth = q.depth
+		f.queueMu.Unlock()
+
+		f.lockedEvent(q.ctx, q.event, q.payload, q.args...)
+	}
+}
+
+// CancelTransition aborts a pending asynchronous transition, leaving the FSM in
+// the source state without calling any more callbacks. It returns
+// NotInTransitionError if no transition is pending.
+func (f *FSM) CancelTransition() error {
+	return f.processQueue(f.startProcessing(), f.cancelTransition)
+}
+
+// cancelTransition clears the pending transition.
+func (f *FSM) cancelTransition() error {
+	f.eventMu.Lock()
+	defer f.eventMu.Unlock()
+	if !f.InTransition() {
+		return NotInTransitionError{}
+	}
+	f.setTransition(nil, nil)
+	return nil
+}
+
+// doTransition wraps Transitioner.Transition.
+func (f *FSM) doTransition() error {
+	return f.transitionerObj.Transition(f)
+}
+
+// DefaultTransitioner returns the Transitioner used by a FSM unless
+// WithTransitioner is given.
+func DefaultTransitioner() Transitioner {
+	return transitionerStruct{}
+}
+
+// transitionerStruct is the default implementation of the Transitioner
+// interface. Other implementations can be swapped in for testing.
+type transitionerStruct struct{}
+
+// Transition completes an asynchrounous state change.
+//
+// The callback for leave_<STATE> must prviously have called Async on its
+// event to have initiated an asynchronous state transition.
+func (t transitionerStruct) Transition(f *FSM) error {
+	f.stateMu.RLock()
+	transition := f.transition
+	f.stateMu.RUnlock()
+
+	if transition == nil {
+		return NotInTransitionError{}
+	}
+	err := transition()
+	f.setTransition(nil, nil)
+	return err
+}
+
+// dsts returns the destinations for the event, or the event it is an alias
+// for, from the source state, falling back to the destinations from AnyState.
+func (f *FSM) dsts(event, src string) ([]eDst, bool) {
+	event = f.canonical(event)
+	if dsts, ok := f.transitions[eKey{event, src}]; ok {
+		return dsts, true
+	}
+	dsts, ok := f.transitions[eKey{event, AnyState}]
+	return dsts, ok
+}
+
+// canonical returns the name of the event that event is an alias for, or event
+// itself if it is not an alias.
+func (f *FSM) canonical(event string) string {
+	event = f.normalize(event)
+	if name, ok := f.aliases[event]; ok {
+		return name
+	}
+	return event
+}
+
+// normalize returns the name normalized by the key normalizer, if set.
+func (f *FSM) normalize(name string) string {
+	if f.normalizer == nil || name == "" || name == AnyState {
+		return name
+	}
+	return f.normalizer(name)
+}
+
+// normalizeDesc returns a copy of the event description with the event, alias
+// and state names normalized by the key normalizer, if set.
+func (f *FSM) normalizeDesc(e EventDesc) EventDesc {
+	if f.normalizer == nil {
+		return e
+	}
+	e.Name = f.normalize(e.Name)
+	if e.Dst != "" {
+		e.Dst = f.normalize(e.Dst)
+	}
+	src := make([]string, len(e.Src))
+	for i, s := range e.Src {
+		src[i] = f.normalize(s)
+	}
+	e.Src = src
+	aliases := make([]string, len(e.Aliases))
+	for i, a := range e.Aliases {
+		aliases[i] = f.normalize(a)
+	}
+	e.Aliases = aliases
+	return e
+}
+
+// resolveDst sets the destination of the event to the first destination whose
+// guard passes, or else to the first destination without a guard, and returns
+// it. It returns false if all guards fail and there is no default.
+func (f *FSM) resolveDst(e *Event, dsts []eDst) (eDst, bool) {
+	var def *eDst
+	for i, d := range dsts {
+		if d.guard == nil {
+			if def == nil {
+				def = &dsts[i]
+			}
+			continue
+		}
+		setDst(e, d)
+		if d.guard(e) {
+			return d, true
+		}
+	}
+	if def != nil {
+		setDst(e, *def)
+		return *def, true
+	}
+	e.Dst = ""
+	e.meta = nil
+	return eDst{}, false
+}
+
+// setDst sets the destination and metadata of the event from d.
+func setDst(e *Event, d eDst) {
+	e.Dst = d.dst
+	if d.action != nil {
+		e.Dst = e.Src
+	}
+	e.meta = d.meta
+}
+
+// beforeEventCallbacks returns a function calling the before_ callbacks, first
+// the named then the general version and the added hooks. The callbacks and
+// the logger are read right away, with eventMu held, so that runPhase can call
+// the function on another goroutine.
+func (f *FSM) beforeEventCallbacks(e *Event) func() error {
+	fns, logger := f.callbacksFor(e.Event, callbackBeforeEvent), f.logger
+	return func() error {
+		logPhase(logger, "before", e)
+		for _, fn := range fns {
+			f.call(fn, e)
+			if e.canceled {
+				logPhase(logger, "canceled", e)
+				return e.canceledError()
+			} else if err := e.ctx.Err(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// leaveStateCallbacks returns a function calling the leave_ callbacks, first
+// the named then the general version and the added hooks, read right away like
+// beforeEventCallbacks.
+func (f *FSM) leaveStateCallbacks(e *Event) func() error {
+	fns, logger := f.callbacksFor(e.Src, callbackLeaveState), f.logger
+	return func() error {
+		logPhase(logger, "leave", e)
+		for _, fn := range fns {
+			f.call(fn, e)
+			if e.canceled {
+				logPhase(logger, "canceled", e)
+				return e.canceledError()
+			} else if err := e.ctx.Err(); err != nil {
+				return err
+			} else if e.async {
+				logPhase(logger, "async", e)
+				return AsyncError{e.Err}
+			}
+		}
+		return nil
+	}
+}
+
+// enterStateCallbacks returns a function calling the enter_ callbacks, first
+// the named then the general version and the added hooks, read right away like
+// beforeEventCallbacks. The function always returns nil.
+func (f *FSM) enterStateCallbacks(e *Event) func() error {
+	fns, logger := f.callbacksFor(e.Dst, callbackEnterState), f.logger
+	return func() error {
+		logPhase(logger, "enter", e)
+		for _, fn := range fns {
+			f.call(fn, e)
+		}
+		return nil
+	}
+}
+
+// afterEventCallbacks returns a function calling the after_ callbacks, first
+// the named then the general version and the added hooks, read right away like
+// beforeEventCallbacks. The function always returns nil.
+func (f *FSM) afterEventCallbacks(e *Event) func() error {
+	fns, logger := f.callbacksFor(e.Event, callbackAfterEvent), f.logger
+	return func() error {
+		logPhase(logger, "after", e)
+		for _, fn := range fns {
+			f.call(fn, e)
+		}
+		return nil
+	}
+}
+
+// call calls a callback, counting it in callbacksRunning while it runs.
+func (f *FSM) call(fn Callback, e *Event) {
+	atomic.AddInt32(&f.callbacksRunning, 1)
+	defer atomic.AddInt32(&f.callbacksRunning, -1)
+	fn(e)
+}
+
+// logPhase logs a phase of the event, if logger is set.
+func logPhase(logger Logger, phase string, e *Event) {
+	if logger != nil {
+		logger.Logf("fsm: %s event %s from %s to %s", phase, e.Event, e.Src, e.Dst)
+	}
+}
+
+// callbacksFor returns the callbacks of a type in the order they are called:
+// the callback for the target, the AnyState callback for a state, the general
+// callback and the added hooks.
+func (f *FSM) callbacksFor(target string, callbackType int) []Callback {
+	var fns []Callback
+	if fn, ok := f.callbacks[cKey{target, callbackType}]; ok {
+		fns = append(fns, fn)
+	}
+	if target != AnyState && (callbackType == callbackLeaveState || callbackType == callbackEnterState) {
+		if fn, ok := f.callbacks[cKey{AnyState, callbackType}]; ok {
+			fns = append(fns, fn)
+		}
+	}
+	if fn, ok := f.callbacks[cKey{"", callbackType}]; ok {
+		fns = append(fns, fn)
+	}
+	return append(fns, f.hooks[callbackType]...)
+}
+
+const (
+	callbackNone int = iota
+	callbackBeforeEvent
+	callbackLeaveState
+	callbackEnterState
+	callbackAfterEvent
+)
+
+// cKey is a struct key used for keeping the callbacks mapped to a target.
+type cKey struct {
+	// target is either the name of a state or an event depending on which
+	// callback type the key refers to. It can also be "" for a non-targeted
+	// callback like before_event.
+	target string
+
+	// callbackType is the situation when the callback will be run.
+	callbackType int
+}
+
+// eKey is a struct key used for storing the transition map.
+type eKey struct {
+	// event is the name of the event that the keys refers to.
+	event string
+
+	// src is the source from where the event can transition.
+	src string
+}
+
+// queuedEvent is an event waiting in the event queue.
+type queuedEvent struct {
+	ctx     context.Context
+	event   string
+	payload interface{}
+	args    []interface{}
+
+	// depth is the number of queued events leading to this one.
+	depth int
+}
+
+// eDst is a destination in the transition map together with its guard.
+type eDst struct {
+	// dst is the destination state of the transition.
+	dst string
+
+	// guard is the optional condition for performing the transition.
+	guard func(*Event) bool
+
+	// choose optionally overrides dst when the transition is performed.
+	choose func(*Event) string
+
+	// action is the function run by an action event, which does not change
+	// the state.
+	action Callback
+
+	// reEnter is true if the transition leaves and re-enters the current state
+	// when it is the destination.
+	reEnter bool
+
+	// meta is the read-only metadata of the transition.
+	meta map[string]interface{}
 }
@@ -15,8 +15,11 @@
 package fsm
 
 import (
+	"context"
 	"fmt"
 	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -25,10 +28,127 @@ import (
 type fakeTransitionerObj struct {
 }
 
-func (t fakeTransitionerObj) transition(f *FSM) error {
+type countingTransitioner struct {
+	calls int
+}
+
+func (t *countingTransitioner) Transition(f *FSM) error {
+	t.calls++
+	return DefaultTransitioner().Transition(f)
+}
+
+type captureLogger struct {
+	lines []string
+}
+
+func (l *captureLogger) Logf(format string, args ...interface{}) {
+	l.lines = append(l.lines, fmt.Sprintf(format, args...))
+}
+
+func (t fakeTransitionerObj) Transition(f *FSM) error {
 	return &InternalError{}
 }
 
+func TestNewFSMWithErrorConflicting(t *testing.T) {
+	_, err := NewFSMWithError(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+			{Name: "run", Src: []string{"start"}, Dst: "other"},
+		},
+		Callbacks{},
+	)
+	if e, ok := err.(ConflictingTransitionError); !ok || e.Event != "run" || e.State != "start" {
+		t.Errorf("expected 'ConflictingTransitionError' with correct state and event, got %v", err)
+	}
+}
+
+func TestNewFSMWithErrorNonConflicting(t *testing.T) {
+	fsm, err := NewFSMWithError(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+			{Name: "run", Src: []string{"start"}, Dst: "other", Guard: func(e *Event) bool {
+				return false
+			}},
+		},
+		Callbacks{},
+	)
+	if err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+	if err := fsm.Event("run"); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+}
+
+func TestNewFSMWithErrorEmptyInitialState(t *testing.T) {
+	_, err := NewFSMWithError(
+		"",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+		},
+		Callbacks{},
+	)
+	if e, ok := err.(EmptyStateError); !ok || e.Event != "" {
+		t.Errorf("expected 'EmptyStateError' for the initial state, got %v", err)
+	}
+}
+
+func TestNewFSMWithErrorEmptyEvent(t *testing.T) {
+	_, err := NewFSMWithError(
+		"start",
+		Events{
+			{Name: "", Src: []string{"start"}, Dst: "end"},
+		},
+		Callbacks{},
+	)
+	if _, ok := err.(EmptyEventError); !ok {
+		t.Errorf("expected 'EmptyEventError', got %v", err)
+	}
+}
+
+func TestNewFSMWithErrorEmptyState(t *testing.T) {
+	_, err := NewFSMWithError(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start", ""}, Dst: "end"},
+		},
+		Callbacks{},
+	)
+	if e, ok := err.(EmptyStateError); !ok || e.Event != "run" {
+		t.Errorf("expected 'EmptyStateError' for the source state, got %v", err)
+	}
+	_, err = NewFSMWithError(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: ""},
+		},
+		Callbacks{},
+	)
+	if e, ok := err.(EmptyStateError); !ok || e.Event != "run" {
+		t.Errorf("expected 'EmptyStateError' for the destination state, got %v", err)
+	}
+}
+
+func TestNewFSMStrictTransitions(t *testing.T) {
+	defer func() {
+		if _, ok := recover().(ConflictingTransitionError); !ok {
+			t.Error("expected NewFSM to panic with 'ConflictingTransitionError'")
+		}
+	}()
+	NewFSM(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+			{Name: "run", Src: []string{"start"}, Dst: "other"},
+		},
+		Callbacks{},
+		WithStrictTransitions(),
+	)
+}
+
 func TestSameState(t *testing.T) {
 	fsm := NewFSM(
 		"start",
@@ -43,6 +163,161 @@ func TestSameState(t *testing.T) {
 	}
 }
 
+func TestSelfTransitionsAreReal(t *testing.T) {
+	for _, real := range []bool{false, true} {
+		var called []string
+		record := func(name string) Callback {
+			return func(e *Event) {
+				called = append(called, name)
+			}
+		}
+		fsm := NewFSM(
+			"start",
+			Events{
+				{Name: "run", Src: []string{"start"}, Dst: "start"},
+			},
+			Callbacks{
+				"before_run":  record("before_run"),
+				"leave_start": record("leave_start"),
+				"enter_start": record("enter_start"),
+				"after_run":   record("after_run"),
+			},
+		)
+		fsm.SetSelfTransitionsAreReal(real)
+
+		err := fsm.Event("run")
+		if fsm.Current() != "start" {
+			t.Error("expected state to be 'start'")
+		}
+		if real {
+			if err != nil {
+				t.Errorf("expected no error, got %v", err)
+			}
+			if fmt.Sprint(called) != "[before_run leave_start enter_start after_run]" {
+				t.Errorf("expected all callbacks to be called, got %v", called)
+			}
+		} else {
+			if _, ok := err.(NoTransitionError); !ok {
+				t.Errorf("expected 'NoTransitionError', got %v", err)
+			}
+			if fmt.Sprint(called) != "[before_run after_run]" {
+				t.Errorf("expected only before and after callbacks to be called, got %v", called)
+			}
+		}
+	}
+}
+
+func TestReEnter(t *testing.T) {
+	var called []string
+	record := func(e *Event) {
+		called = append(called, e.Event)
+	}
+	fsm := NewFSM(
+		"idle",
+		Events{
+			{Name: "refresh", Src: []string{"idle"}, Dst: "idle", ReEnter: true},
+			{Name: "poke", Src: []string{"idle"}, Dst: "idle"},
+		},
+		Callbacks{
+			"leave_idle": record,
+			"enter_idle": record,
+		},
+	)
+
+	if err := fsm.Event("refresh"); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+	if fmt.Sprint(called) != "[refresh refresh]" {
+		t.Errorf("expected leave_idle and enter_idle for refresh, got %v", called)
+	}
+
+	called = nil
+	if _, ok := fsm.Event("poke").(NoTransitionError); !ok {
+		t.Error("expected 'NoTransitionError' for a plain self-transition")
+	}
+	if len(called) != 0 {
+		t.Errorf("expected no leave_idle or enter_idle for poke, got %v", called)
+	}
+}
+
+func TestReEnterCanceled(t *testing.T) {
+	entered := false
+	fsm := NewFSM(
+		"idle",
+		Events{
+			{Name: "refresh", Src: []string{"idle"}, Dst: "idle", ReEnter: true},
+		},
+		Callbacks{
+			"leave_idle": func(e *Event) {
+				e.Cancel()
+			},
+			"enter_idle": func(e *Event) {
+				entered = true
+			},
+		},
+	)
+	if _, ok := fsm.Event("refresh").(CanceledError); !ok {
+		t.Error("expected 'CanceledError'")
+	}
+	if entered {
+		t.Error("expected enter_idle not to be called")
+	}
+}
+
+func TestActionEvent(t *testing.T) {
+	var called []string
+	record := func(name string) Callback {
+		return func(e *Event) {
+			called = append(called, name)
+		}
+	}
+	fsm, err := NewFSMWithError(
+		"up",
+		Events{
+			{Name: "ping", Src: []string{"up"}, Action: func(e *Event) {
+				called = append(called, "action:"+e.Src+">"+e.Dst)
+			}},
+			{Name: "down", Src: []string{"up"}, Dst: "down"},
+		},
+		Callbacks{
+			"before_ping": record("before_ping"),
+			"leave_up":    record("leave_up"),
+			"enter_up":    record("enter_up"),
+			"after_ping":  record("after_ping"),
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dst, ok := fsm.Peek("ping"); !ok || dst != "up" {
+		t.Errorf("expected to peek 'up', got %q", dst)
+	}
+	if err := fsm.Event("ping"); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+	if fsm.Current() != "up" {
+		t.Error("expected state to be 'up'")
+	}
+	if fmt.Sprint(called) != "[before_ping action:up>up after_ping]" {
+		t.Errorf("expected only the before, action and after callbacks, got %v", called)
+	}
+}
+
+func TestActionEventError(t *testing.T) {
+	fsm := NewFSM(
+		"up",
+		Events{
+			{Name: "ping", Src: []string{"up"}, Action: func(e *Event) {
+				e.Err = fmt.Errorf("ping failed")
+			}},
+		},
+		Callbacks{},
+	)
+	if err := fsm.Event("ping"); err == nil || err.Error() != "ping failed" {
+		t.Errorf("expected the error from the action, got %v", err)
+	}
+}
+
 func TestSetState(t *testing.T) {
 	fsm := NewFSM(
 		"walking",
@@ -61,452 +336,3454 @@ func TestSetState(t *testing.T) {
 	}
 }
 
-func TestBadTransition(t *testing.T) {
+func TestSetStateFromCallback(t *testing.T) {
+	var fsm *FSM
+	fsm = NewFSM(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "running"},
+			{Name: "stop", Src: []string{"running"}, Dst: "end"},
+		},
+		Callbacks{
+			"enter_running": func(e *Event) {
+				fsm.SetState("end")
+			},
+			"after_stop": func(e *Event) {
+				if err := fsm.RestoreState("start"); err != nil {
+					e.Err = err
+				}
+			},
+		},
+	)
+
+	if err := fsm.Event("run"); err != nil {
+		t.Fatal(err)
+	}
+	if fsm.Current() != "end" {
+		t.Errorf("expected the enter_ callback to move to 'end', got %s", fsm.Current())
+	}
+	fsm.SetState("running")
+	if err := fsm.Event("stop"); err != nil {
+		t.Fatal(err)
+	}
+	if fsm.Current() != "start" {
+		t.Errorf("expected the after_ callback to move to 'start', got %s", fsm.Current())
+	}
+}
+
+func TestFireInitialEnter(t *testing.T) {
+	var entered []string
+	fsm := NewFSM(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+		},
+		Callbacks{
+			"enter_start": func(e *Event) {
+				entered = append(entered, e.Src+">"+e.Dst)
+			},
+		},
+	)
+	if len(entered) != 0 {
+		t.Error("expected no enter callbacks on construction")
+	}
+	if err := fsm.FireInitialEnter(); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+	if err := fsm.FireInitialEnter(); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+	if fmt.Sprint(entered) != "[>start]" {
+		t.Errorf("expected enter_start to be called once, got %v", entered)
+	}
+}
+
+func TestFireInitialEnterError(t *testing.T) {
+	fsm := NewFSM(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+		},
+		Callbacks{
+			"enter_state": func(e *Event) {
+				e.Err = fmt.Errorf("enter failed")
+			},
+		},
+	)
+	fsm.SetState("end")
+	if err := fsm.FireInitialEnter(); err != nil {
+		t.Errorf("expected no callbacks outside the initial state, got %v", err)
+	}
+	fsm.SetState("start")
+	if err := fsm.FireInitialEnter(); err == nil || err.Error() != "enter failed" {
+		t.Errorf("expected the error from the callback, got %v", err)
+	}
+}
+
+func TestSetStateDuringAsyncTransition(t *testing.T) {
+	fsm := NewFSM(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+		},
+		Callbacks{
+			"leave_start": func(e *Event) {
+				e.Async()
+			},
+		},
+	)
+
+	if _, ok := fsm.Event("run").(AsyncError); !ok {
+		t.Fatal("expected an asynchronous transition")
+	}
+	fsm.SetState("other")
+	if fsm.InTransition() {
+		t.Error("expected SetState to clear the pending transition")
+	}
+	if _, ok := fsm.Transition().(NotInTransitionError); !ok {
+		t.Error("expected 'NotInTransitionError' after SetState")
+	}
+	if fsm.Current() != "other" {
+		t.Errorf("expected state to stay 'other', got %s", fsm.Current())
+	}
+}
+
+func TestNextEvent(t *testing.T) {
+	for _, test := range []struct {
+		amount int
+		state  string
+	}{
+		{50, "approved"},
+		{500, "review"},
+	} {
+		var entered []string
+		fsm := NewFSM(
+			"idle",
+			Events{
+				{Name: "submit", Src: []string{"idle"}, Dst: "deciding"},
+				{Name: "approve", Src: []string{"deciding"}, Dst: "approved"},
+				{Name: "escalate", Src: []string{"deciding"}, Dst: "review"},
+			},
+			Callbacks{
+				"enter_state": func(e *Event) {
+					entered = append(entered, e.Dst)
+				},
+				"enter_deciding": func(e *Event) {
+					if e.Args[0].(int) < 100 {
+						e.NextEvent("approve")
+					} else {
+						e.NextEvent("escalate")
+					}
+				},
+			},
+		)
+
+		if err := fsm.Event("submit", test.amount); err != nil {
+			t.Fatal(err)
+		}
+		if fsm.Current() != test.state {
+			t.Errorf("expected state to be '%s', got %s", test.state, fsm.Current())
+		}
+		if fmt.Sprint(entered) != fmt.Sprint([]string{"deciding", test.state}) {
+			t.Errorf("expected the decision state to be entered first, got %v", entered)
+		}
+	}
+}
+
+func TestNextEventError(t *testing.T) {
+	fsm := NewFSM(
+		"idle",
+		Events{
+			{Name: "submit", Src: []string{"idle"}, Dst: "deciding"},
+		},
+		Callbacks{
+			"enter_deciding": func(e *Event) {
+				e.NextEvent("submit")
+			},
+		},
+	)
+
+	err := fsm.Event("submit")
+	if e, ok := err.(InvalidEventError); !ok || e.Event != "submit" || e.State != "deciding" {
+		t.Errorf("expected the error of the next event, got %v", err)
+	}
+	if fsm.Current() != "deciding" {
+		t.Errorf("expected state to be 'deciding', got %s", fsm.Current())
+	}
+}
+
+func TestRunPhase(t *testing.T) {
+	var called []string
+	fsm := NewFSM(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+		},
+		Callbacks{
+			"before_run": func(e *Event) {
+				called = append(called, "before_run")
+				if len(e.Args) > 0 {
+					e.Cancel()
+				}
+			},
+			"leave_start": func(e *Event) {
+				called = append(called, "leave_start")
+				if len(e.Args) > 0 {
+					e.Async()
+				}
+			},
+			"enter_end": func(e *Event) {
+				called = append(called, fmt.Sprintf("enter_end %s->%s", e.Src, e.Dst))
+				if len(e.Args) > 0 {
+					e.Err = fmt.Errorf("enter failed")
+				}
+			},
+			"after_run": func(e *Event) {
+				called = append(called, "after_run")
+			},
+		},
+	)
+
+	tests := []struct {
+		phase    Phase
+		args     []interface{}
+		expected string
+		err      string
+	}{
+		{PhaseBefore, nil, "before_run", "<nil>"},
+		{PhaseBefore, []interface{}{true}, "before_run", "transition canceled"},
+		{PhaseLeave, nil, "leave_start", "<nil>"},
+		{PhaseLeave, []interface{}{true}, "leave_start", "async started"},
+		{PhaseEnter, nil, "enter_end start->end", "<nil>"},
+		{PhaseEnter, []interface{}{true}, "enter_end start->end", "enter failed"},
+		{PhaseAfter, nil, "after_run", "<nil>"},
+	}
+	for _, test := range tests {
+		called = nil
+		err := fsm.RunPhase(test.phase, "run", "start", "end", test.args...)
+		if fmt.Sprint(err) != test.err {
+			t.Errorf("expected error %s from phase %d, got %v", test.err, test.phase, err)
+		}
+		if fmt.Sprint(called) != "["+test.expected+"]" {
+			t.Errorf("expected %s to be called in phase %d, got %v", test.expected, test.phase, called)
+		}
+	}
+	if fsm.Current() != "start" || fsm.InTransition() {
+		t.Error("expected the state to be unchanged")
+	}
+}
+
+func TestOnEnterAnyState(t *testing.T) {
+	var called []string
+	fsm := NewFSM(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+			{Name: "back", Src: []string{"end"}, Dst: "start"},
+		},
+		Callbacks{
+			"enter_state": func(e *Event) {
+				called = append(called, "enter_state")
+			},
+		},
+	)
+	fsm.OnEnter("end", func(e *Event) {
+		called = append(called, "enter_end")
+	})
+	fsm.OnEnter(AnyState, func(e *Event) {
+		called = append(called, "enter_*:"+e.Dst)
+	})
+	fsm.OnLeave(AnyState, func(e *Event) {
+		called = append(called, "leave_*:"+e.Src)
+	})
+
+	fsm.Event("run")
+	fsm.Event("back")
+
+	expected := []string{
+		"leave_*:start", "enter_end", "enter_*:end", "enter_state",
+		"leave_*:end", "enter_*:start", "enter_state",
+	}
+	if fmt.Sprint(called) != fmt.Sprint(expected) {
+		t.Errorf("expected %v, got %v", expected, called)
+	}
+}
+
+func TestAddTransition(t *testing.T) {
+	fsm := NewFSM(
+		"closed",
+		Events{
+			{Name: "open", Src: []string{"closed"}, Dst: "open"},
+			{Name: "close", Src: []string{"open"}, Dst: "closed"},
+		},
+		Callbacks{},
+	)
+	if fsm.Can("lock") {
+		t.Error("expected 'lock' not to be defined yet")
+	}
+
+	if err := fsm.AddTransition("lock", "closed", "locked"); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(fsm.AvailableTransitions()) != "[lock open]" {
+		t.Errorf("expected 'lock' to be available, got %v", fsm.AvailableTransitions())
+	}
+	if err := fsm.Event("lock"); err != nil {
+		t.Fatal(err)
+	}
+	if fsm.Current() != "locked" {
+		t.Errorf("expected state to be 'locked', got %s", fsm.Current())
+	}
+	if fmt.Sprint(fsm.States()) != "[closed locked open]" {
+		t.Errorf("expected 'locked' to be a state, got %v", fsm.States())
+	}
+
+	if _, ok := fsm.AddTransition("", "closed", "open").(EmptyEventError); !ok {
+		t.Error("expected 'EmptyEventError' for an empty event")
+	}
+	if _, ok := fsm.AddTransition("unlock", "locked", "").(EmptyStateError); !ok {
+		t.Error("expected 'EmptyStateError' for an empty state")
+	}
+}
+
+func TestAddTransitionStrict(t *testing.T) {
+	fsm := NewFSM(
+		"closed",
+		Events{
+			{Name: "open", Src: []string{"closed"}, Dst: "open"},
+		},
+		Callbacks{},
+		WithStrictTransitions(),
+	)
+	err := fsm.AddTransition("open", "closed", "broken")
+	if e, ok := err.(ConflictingTransitionError); !ok || e.Event != "open" || e.State != "closed" {
+		t.Errorf("expected 'ConflictingTransitionError', got %v", err)
+	}
+	if dst, _ := fsm.Peek("open"); dst != "open" {
+		t.Errorf("expected the conflicting transition not to be added, got %s", dst)
+	}
+}
+
+func TestAddTransitionAlias(t *testing.T) {
+	fsm := NewFSM(
+		"closed",
+		Events{
+			{Name: "open", Src: []string{"closed"}, Dst: "open", Aliases: []string{"unlatch"}},
+		},
+		Callbacks{},
+	)
+	if err := fsm.AddTransition("unlatch", "open", "ajar"); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(fsm.Events()) != "[open]" {
+		t.Errorf("expected the transition to be added to 'open', got events %v", fsm.Events())
+	}
+	fsm.SetState("open")
+	if dst, _ := fsm.Peek("open"); dst != "ajar" {
+		t.Errorf("expected 'open' to lead to 'ajar', got %s", dst)
+	}
+}
+
+func TestAddTransitionConcurrent(t *testing.T) {
+	fsm := NewFSM(
+		"closed",
+		Events{
+			{Name: "open", Src: []string{"closed"}, Dst: "open"},
+		},
+		Callbacks{},
+	)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			if err := fsm.AddTransition("event"+strconv.Itoa(i), "open", "closed"); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			fsm.Events()
+			fsm.TransitionTable()
+			fsm.Validate()
+			fsm.PathTo("open")
+			Visualize(fsm)
+			VisualizeMermaid(fsm)
+			VisualizeMerged(fsm)
+		}
+	}()
+	wg.Wait()
+
+	if len(fsm.Events()) != 101 {
+		t.Errorf("expected 101 events, got %d", len(fsm.Events()))
+	}
+}
+
+func TestAddTransitionInTransition(t *testing.T) {
+	fsm := NewFSM(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+		},
+		Callbacks{
+			"leave_start": func(e *Event) {
+				e.Async()
+			},
+		},
+	)
+	fsm.Event("run")
+
+	err := fsm.AddTransition("back", "end", "start")
+	if e, ok := err.(InTransitionError); !ok || e.Src != "start" || e.Dst != "end" {
+		t.Errorf("expected 'InTransitionError', got %v", err)
+	}
+	if _, ok := fsm.RemoveTransition("run", "start").(InTransitionError); !ok {
+		t.Error("expected 'InTransitionError' when removing")
+	}
+}
+
+func TestRemoveTransition(t *testing.T) {
+	fsm := NewFSM(
+		"closed",
+		Events{
+			{Name: "open", Src: []string{"closed"}, Dst: "open"},
+			{Name: "close", Src: []string{"open"}, Dst: "closed"},
+			{Name: "kick", Src: []string{"closed", "open"}, Dst: "broken"},
+		},
+		Callbacks{},
+	)
+
+	if err := fsm.RemoveTransition("kick", "closed"); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(fsm.AvailableTransitions()) != "[open]" {
+		t.Errorf("expected only 'open' to be available, got %v", fsm.AvailableTransitions())
+	}
+	err := fsm.Event("kick")
+	if e, ok := err.(InvalidEventError); !ok || e.Event != "kick" || e.State != "closed" {
+		t.Errorf("expected 'InvalidEventError', got %v", err)
+	}
+
+	if _, ok := fsm.RemoveTransition("kick", "closed").(InvalidEventError); !ok {
+		t.Error("expected 'InvalidEventError' for a removed transition")
+	}
+	if err := fsm.RemoveTransition("kick", "open"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fsm.RemoveTransition("kick", "open").(UnknownEventError); !ok {
+		t.Error("expected 'UnknownEventError' once the event is removed from all states")
+	}
+	if fmt.Sprint(fsm.States()) != "[closed open]" {
+		t.Errorf("expected 'broken' to no longer be a state, got %v", fsm.States())
+	}
+}
+
+func TestRestoreState(t *testing.T) {
+	fsm := NewFSM(
+		"walking",
+		Events{
+			{Name: "walk", Src: []string{"start"}, Dst: "walking"},
+		},
+		Callbacks{},
+	)
+	if err := fsm.RestoreState("start"); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+	if fsm.Current() != "start" {
+		t.Error("expected state to be 'start'")
+	}
+}
+
+func TestRestoreUnknownState(t *testing.T) {
+	fsm := NewFSM(
+		"walking",
+		Events{
+			{Name: "walk", Src: []string{"start"}, Dst: "walking"},
+		},
+		Callbacks{},
+	)
+	err := fsm.RestoreState("flying")
+	if e, ok := err.(UnknownStateError); !ok || e.State != "flying" {
+		t.Errorf("expected 'UnknownStateError' with correct state, got %v", err)
+	}
+	if fsm.Current() != "walking" {
+		t.Error("expected state to be 'walking'")
+	}
+}
+
+func TestClone(t *testing.T) {
+	entered := 0
+	fsm := NewFSM(
+		"closed",
+		Events{
+			{Name: "open", Src: []string{"closed"}, Dst: "open"},
+			{Name: "close", Src: []string{"open"}, Dst: "closed"},
+		},
+		Callbacks{
+			"enter_open": func(e *Event) {
+				entered++
+			},
+		},
+	)
+	fsm.Event("open")
+
+	clone := fsm.Clone()
+	if clone.Current() != "closed" {
+		t.Error("expected clone to be in the initial state 'closed'")
+	}
+	if err := clone.Event("open"); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+	clone.OnEnter("closed", func(e *Event) {
+		t.Error("expected callbacks of the clone not to affect the original")
+	})
+	if entered != 2 {
+		t.Error("expected callbacks to be cloned")
+	}
+	if err := fsm.Event("close"); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+	if fsm.Current() != "closed" || clone.Current() != "open" {
+		t.Error("expected the original and the clone to be independent")
+	}
+}
+
+func TestCloneStrictTransitions(t *testing.T) {
+	fsm := NewFSM(
+		"closed",
+		Events{
+			{Name: "open", Src: []string{"closed"}, Dst: "open"},
+		},
+		Callbacks{},
+		WithStrictTransitions(),
+	)
+	clone := fsm.Clone()
+	if _, ok := clone.AddTransition("open", "closed", "broken").(ConflictingTransitionError); !ok {
+		t.Error("expected the clone to keep the strict transitions")
+	}
+}
+
+func TestCloneSettings(t *testing.T) {
+	var observed, rejected, unknown, errs []string
+	logger := &captureLogger{}
+	fsm := NewFSM(
+		"closed",
+		Events{
+			{Name: "open", Src: []string{"closed"}, Dst: "open"},
+			{Name: "stay", Src: []string{"open"}, Dst: "open"},
+			{Name: "kick", Src: []string{"open"}, Dst: "broken"},
+			{Name: "close", Src: []string{"open"}, Dst: "closed"},
+		},
+		Callbacks{
+			"enter_closed": func(e *Event) {
+				e.Err = fmt.Errorf("enter failed")
+			},
+			"leave_broken": func(e *Event) {
+				panic("leave")
+			},
+		},
+	)
+	fsm.SetObserver(func(from, to, event string, d time.Duration) {
+		observed = append(observed, event)
+	})
+	fsm.SetErrorObserver(func(event, src string, err error) {
+		errs = append(errs, event)
+	}, true)
+	fsm.OnReject(func(event, state string, reason error) {
+		rejected = append(rejected, event)
+	})
+	fsm.SetUnknownEventHandler(func(event string, args ...interface{}) error {
+		unknown = append(unknown, event)
+		return nil
+	})
+	fsm.SetLogger(logger)
+	fsm.SetRecoverPanics(true)
+	fsm.SetRollbackOnError(true)
+	fsm.SetSelfTransitionsAreReal(true)
+	fsm.SetCallbackTimeout(time.Second)
+	fsm.DisableEvent("kick")
+	fsm.SetMaxTransitionDepth(3)
+
+	clone := fsm.Clone()
+	if err := clone.Event("open"); err != nil {
+		t.Fatal(err)
+	}
+	if err := clone.Event("stay"); err != nil {
+		t.Errorf("expected self transitions to be real in the clone, got %v", err)
+	}
+	if _, ok := clone.Event("kick").(DisabledEventError); !ok {
+		t.Error("expected 'kick' to be disabled in the clone")
+	}
+	if _, ok := clone.Event("close").(RollbackError); !ok {
+		t.Error("expected rollback to be enabled in the clone")
+	}
+	clone.Event("open")
+	if err := clone.Event("jump"); err != nil {
+		t.Errorf("expected the unknown event handler in the clone, got %v", err)
+	}
+
+	if fmt.Sprint(observed) != "[open stay]" {
+		t.Errorf("expected the observer in the clone, got %v", observed)
+	}
+	if fmt.Sprint(rejected) != "[open]" {
+		t.Errorf("expected the reject handler in the clone, got %v", rejected)
+	}
+	if fmt.Sprint(unknown) != "[jump]" {
+		t.Errorf("expected the unknown event handler in the clone, got %v", unknown)
+	}
+	if fmt.Sprint(errs) != "[kick close open]" {
+		t.Errorf("expected the error observer in the clone, got %v", errs)
+	}
+	if len(logger.lines) == 0 {
+		t.Error("expected the logger in the clone")
+	}
+
+	clone.SetState("broken")
+	clone.AddTransition("fix", "broken", "open")
+	if _, ok := clone.Event("fix").(CallbackPanicError); !ok {
+		t.Error("expected panics to be recovered in the clone")
+	}
+
+	if clone.callbackTimeout != time.Second || clone.maxDepth != 3 {
+		t.Error("expected the callback timeout and maximum depth in the clone")
+	}
+}
+
+func TestReset(t *testing.T) {
+	var calls []string
+	fsm := NewFSM(
+		"closed",
+		Events{
+			{Name: "open", Src: []string{"closed"}, Dst: "open"},
+		},
+		Callbacks{
+			"leave_open": func(e *Event) {
+				calls = append(calls, "leave_open")
+			},
+			"enter_closed": func(e *Event) {
+				calls = append(calls, "enter_closed")
+			},
+		},
+	)
+	fsm.Event("open")
+	fsm.Reset(false)
+	if fsm.Current() != "closed" {
+		t.Error("expected state to be 'closed'")
+	}
+	if len(calls) != 0 {
+		t.Errorf("expected no callbacks to be called, got %v", calls)
+	}
+
+	fsm.Event("open")
+	fsm.Reset(true)
+	if fsm.Current() != "closed" {
+		t.Error("expected state to be 'closed'")
+	}
+	expected := []string{"leave_open", "enter_closed"}
+	if fmt.Sprint(calls) != fmt.Sprint(expected) {
+		t.Errorf("expected %v, got %v", expected, calls)
+	}
+}
+
+func TestResetAsyncTransition(t *testing.T) {
+	fsm := NewFSM(
+		"closed",
+		Events{
+			{Name: "open", Src: []string{"closed"}, Dst: "open"},
+		},
+		Callbacks{
+			"leave_closed": func(e *Event) {
+				e.Async()
+			},
+		},
+	)
+	fsm.Event("open")
+	fsm.Reset(false)
+	if _, ok := fsm.Transition().(NotInTransitionError); !ok {
+		t.Error("expected the pending transition to be cleared")
+	}
+	if !fsm.Can("open") {
+		t.Error("expected to be able to open after reset")
+	}
+}
+
+func TestBadTransition(t *testing.T) {
+	fsm := NewFSM(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "running"},
+		},
+		Callbacks{},
+		WithTransitioner(new(fakeTransitionerObj)),
+	)
+	err := fsm.Event("run")
+	if err == nil {
+		t.Error("bad transition should give an error")
+	}
+}
+
+func TestWithTransitioner(t *testing.T) {
+	transitioner := &countingTransitioner{}
+	fsm := NewFSM(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "running"},
+			{Name: "stop", Src: []string{"running"}, Dst: "start"},
+		},
+		Callbacks{
+			"leave_running": func(e *Event) {
+				e.Async()
+			},
+		},
+		WithTransitioner(transitioner),
+	)
+	if err := fsm.Event("run"); err != nil {
+		t.Fatal(err)
+	}
+	if fsm.Current() != "running" {
+		t.Error("expected state to be 'running'")
+	}
+	if err := fsm.Event("stop"); err == nil {
+		t.Error("expected 'AsyncError'")
+	}
+	if err := fsm.Transition(); err != nil {
+		t.Fatal(err)
+	}
+	if fsm.Current() != "start" {
+		t.Error("expected state to be 'start'")
+	}
+	if transitioner.calls != 2 {
+		t.Errorf("expected 2 calls to the transitioner, got %d", transitioner.calls)
+	}
+}
+
+func TestInappropriateEvent(t *testing.T) {
+	fsm := NewFSM(
+		"closed",
+		Events{
+			{Name: "open", Src: []string{"closed"}, Dst: "open"},
+			{Name: "close", Src: []string{"open"}, Dst: "closed"},
+		},
+		Callbacks{},
+	)
+	err := fsm.Event("close")
+	if e, ok := err.(InvalidEventError); !ok && e.Event != "close" && e.State != "closed" {
+		t.Error("expected 'InvalidEventError' with correct state and event")
+	}
+}
+
+func TestInappropriateEventAlternatives(t *testing.T) {
+	fsm := NewFSM(
+		"closed",
+		Events{
+			{Name: "open", Src: []string{"closed"}, Dst: "open", Description: "open the door"},
+			{Name: "lock", Src: []string{"closed"}, Dst: "locked", Description: "lock the door"},
+			{Name: "close", Src: []string{"open"}, Dst: "closed"},
+		},
+		Callbacks{},
+	)
+	err := fsm.Event("close")
+	if err != (InvalidEventError{Event: "close", State: "closed"}) {
+		t.Errorf("expected no alternatives by default, got %v", err)
+	}
+	if err.Error() != "event close inappropriate in current state closed" {
+		t.Errorf("expected the default message, got %v", err)
+	}
+
+	fsm.SetSuggestAlternatives(true)
+	err = fsm.Event("close")
+	if err == nil || err.Error() != "event close inappropriate in current state closed, try lock (lock the door) or open (open the door) instead" {
+		t.Errorf("expected an error suggesting lock or open, got %v", err)
+	}
+	if err != (InvalidEventError{Event: "close", State: "closed", Alternatives: "lock (lock the door) or open (open the door)"}) {
+		t.Errorf("expected the error to compare equal, got %#v", err)
+	}
+	if fsm.Description("open") != "open the door" {
+		t.Errorf("expected the description of open, got %q", fsm.Description("open"))
+	}
+	if fsm.Description("close") != "" {
+		t.Errorf("expected no description of close, got %q", fsm.Description("close"))
+	}
+}
+
+func TestInvalidEvent(t *testing.T) {
+	fsm := NewFSM(
+		"closed",
+		Events{
+			{Name: "open", Src: []string{"closed"}, Dst: "open"},
+			{Name: "close", Src: []string{"open"}, Dst: "closed"},
+		},
+		Callbacks{},
+	)
+	err := fsm.Event("lock")
+	if e, ok := err.(UnknownEventError); !ok && e.Event != "close" {
+		t.Error("expected 'UnknownEventError' with correct event")
+	}
+}
+
+func TestUnknownEventHandler(t *testing.T) {
+	var handled []interface{}
+	fsm := NewFSM(
+		"closed",
+		Events{
+			{Name: "open", Src: []string{"closed"}, Dst: "open"},
+			{Name: "close", Src: []string{"open"}, Dst: "closed"},
+		},
+		Callbacks{},
+	)
+	fsm.SetUnknownEventHandler(func(event string, args ...interface{}) error {
+		handled = append(handled, event)
+		handled = append(handled, args...)
+		return nil
+	})
+	if err := fsm.Event("lock", "key"); err != nil {
+		t.Errorf("expected the handler's result, got %v", err)
+	}
+	if fmt.Sprint(handled) != "[lock key]" {
+		t.Errorf("expected the handler to be called with the event and args, got %v", handled)
+	}
+	if _, ok := fsm.Event("close").(InvalidEventError); !ok {
+		t.Error("expected 'InvalidEventError' for an event invalid in the current state")
+	}
+
+	fsm.SetUnknownEventHandler(nil)
+	if _, ok := fsm.Event("lock").(UnknownEventError); !ok {
+		t.Error("expected 'UnknownEventError' without a handler")
+	}
+}
+
+func TestFinalStates(t *testing.T) {
+	fsm := NewFSM(
+		"open",
+		Events{
+			{Name: "close", Src: []string{"open"}, Dst: "closed"},
+			{Name: "open", Src: []string{"closed"}, Dst: "open"},
+			{Name: "kick", Src: []string{AnyState}, Dst: "broken"},
+		},
+		Callbacks{},
+	)
+	fsm.SetFinalStates("broken")
+	if fsm.IsFinal() {
+		t.Error("expected 'open' not to be final")
+	}
+	fsm.Event("kick")
+	if !fsm.IsFinal() {
+		t.Error("expected 'broken' to be final")
+	}
+	err := fsm.Event("kick")
+	if e, ok := err.(TerminalStateError); !ok || e.Event != "kick" || e.State != "broken" {
+		t.Errorf("expected 'TerminalStateError' with correct state and event, got %v", err)
+	}
+	if fsm.Can("kick") {
+		t.Error("expected no events to be possible in a final state")
+	}
+	if transitions := fsm.AvailableTransitions(); len(transitions) != 0 {
+		t.Errorf("expected no transitions in a final state, got %v", transitions)
+	}
+}
+
+func TestIsStuck(t *testing.T) {
+	fsm := NewFSM(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "running"},
+			{Name: "finish", Src: []string{"running"}, Dst: "done"},
+			{Name: "fail", Src: []string{"running"}, Dst: "failed"},
+		},
+		Callbacks{},
+	)
+	fsm.SetFinalStates("done")
+
+	if fsm.IsStuck() || !fsm.CanFireAny() {
+		t.Error("expected 'start' to have outgoing transitions")
+	}
+
+	fsm.SetState("done")
+	if fsm.IsStuck() {
+		t.Error("expected final state 'done' not to be stuck")
+	}
+	if fsm.CanFireAny() {
+		t.Error("expected no events to be possible in final state 'done'")
+	}
+
+	fsm.SetState("failed")
+	if !fsm.IsStuck() {
+		t.Error("expected 'failed' to be stuck")
+	}
+	if fsm.CanFireAny() {
+		t.Error("expected no events to be possible in 'failed'")
+	}
+}
+
+func TestDisableEvent(t *testing.T) {
+	fsm := NewFSM(
+		"closed",
+		Events{
+			{Name: "open", Src: []string{"closed"}, Dst: "open", Aliases: []string{"unlock"}},
+			{Name: "close", Src: []string{"open"}, Dst: "closed"},
+		},
+		Callbacks{},
+	)
+	fsm.DisableEvent("unlock")
+	if fsm.Can("open") {
+		t.Error("expected disabled event not to be possible")
+	}
+	if transitions := fsm.AvailableTransitions(); len(transitions) != 0 {
+		t.Errorf("expected no transitions, got %v", transitions)
+	}
+	err := fsm.Event("open")
+	if e, ok := err.(DisabledEventError); !ok || e.Event != "open" {
+		t.Errorf("expected 'DisabledEventError' for 'open', got %v", err)
+	}
+	if fsm.Current() != "closed" {
+		t.Error("expected state to be 'closed'")
+	}
+
+	fsm.EnableEvent("open")
+	if !fsm.Can("open") {
+		t.Error("expected enabled event to be possible")
+	}
+	if err := fsm.Event("unlock"); err != nil {
+		t.Fatal(err)
+	}
+	if fsm.Current() != "open" {
+		t.Error("expected state to be 'open'")
+	}
+}
+
+func TestFreeze(t *testing.T) {
+	fsm := NewFSM(
+		"closed",
+		Events{
+			{Name: "open", Src: []string{"closed"}, Dst: "open"},
+			{Name: "close", Src: []string{"open"}, Dst: "closed"},
+		},
+		Callbacks{
+			"leave_open": func(e *Event) {
+				e.Async()
+			},
+		},
+	)
+	fsm.Freeze()
+	if fsm.Can("open") {
+		t.Error("expected no events to be possible while frozen")
+	}
+	if transitions := fsm.AvailableTransitions(); len(transitions) != 0 {
+		t.Errorf("expected no transitions while frozen, got %v", transitions)
+	}
+	err := fsm.Event("open")
+	if e, ok := err.(FrozenError); !ok || e.Event != "open" {
+		t.Errorf("expected 'FrozenError' for 'open', got %v", err)
+	}
+	if fsm.Current() != "closed" {
+		t.Error("expected state to be 'closed'")
+	}
+
+	fsm.Unfreeze()
+	if !fsm.Can("open") {
+		t.Error("expected events to be possible after unfreezing")
+	}
+	if err := fsm.Event("open"); err != nil {
+		t.Fatal(err)
+	}
+	if fsm.Current() != "open" {
+		t.Error("expected state to be 'open'")
+	}
+
+	fsm.Event("close")
+	fsm.Freeze()
+	if _, ok := fsm.Transition().(FrozenError); !ok {
+		t.Error("expected 'FrozenError' for the pending transition")
+	}
+	fsm.Unfreeze()
+	if err := fsm.Transition(); err != nil {
+		t.Fatal(err)
+	}
+	if fsm.Current() != "closed" {
+		t.Error("expected state to be 'closed'")
+	}
+}
+
+func TestEventResult(t *testing.T) {
+	fsm := NewFSM(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "running"},
+			{Name: "stay", Src: []string{"running"}, Dst: "running"},
+			{Name: "stop", Src: []string{"running"}, Dst: "end"},
+		},
+		Callbacks{
+			"leave_running": func(e *Event) {
+				if e.Event == "stop" {
+					e.Async()
+				}
+			},
+		},
+	)
+	tests := []struct {
+		event   string
+		outcome Outcome
+		err     bool
+	}{
+		{"run", OutcomeTransitioned, false},
+		{"stay", OutcomeNoChange, false},
+		{"run", OutcomeRejected, true},
+		{"stop", OutcomeAsyncPending, false},
+	}
+	for _, test := range tests {
+		outcome, err := fsm.EventResult(test.event)
+		if outcome != test.outcome || (err != nil) != test.err {
+			t.Errorf("expected outcome %d and error %v for %s, got %d and %v", test.outcome, test.err, test.event, outcome, err)
+		}
+	}
+}
+
+func TestMustEvent(t *testing.T) {
+	fsm := NewFSM(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+		},
+		Callbacks{},
+	)
+	fsm.MustEvent("run")
+	if fsm.Current() != "end" {
+		t.Error("expected state to be 'end'")
+	}
+
+	defer func() {
+		r := recover()
+		if e, ok := r.(InvalidEventError); !ok || e.Event != "run" || e.State != "end" {
+			t.Errorf("expected panic with 'InvalidEventError', got %v", r)
+		}
+	}()
+	fsm.MustEvent("run")
+	t.Error("expected MustEvent to panic")
+}
+
+func TestTransitionTable(t *testing.T) {
+	fsm := NewFSM(
+		"closed",
+		Events{
+			{Name: "open", Src: []string{"closed"}, Dst: "open"},
+			{Name: "close", Src: []string{"open"}, Dst: "closed"},
+			{Name: "kick", Src: []string{"closed", "open"}, Dst: "broken"},
+			{Name: "fix", Src: []string{AnyState}, Dst: "closed"},
+			{Name: "knock", Src: []string{"closed"}, Action: func(e *Event) {}},
+		},
+		Callbacks{},
+	)
+	expected := []TransitionInfo{
+		{"fix", "*", "closed"},
+		{"kick", "closed", "broken"},
+		{"knock", "closed", "closed"},
+		{"open", "closed", "open"},
+		{"close", "open", "closed"},
+		{"kick", "open", "broken"},
+	}
+	if table := fsm.TransitionTable(); fmt.Sprint(table) != fmt.Sprint(expected) {
+		t.Errorf("expected %v, got %v", expected, table)
+	}
+}
+
+func TestIncomingTransitions(t *testing.T) {
+	fsm := NewFSM(
+		"closed",
+		Events{
+			{Name: "open", Src: []string{"closed"}, Dst: "open"},
+			{Name: "close", Src: []string{"open"}, Dst: "closed"},
+			{Name: "kick", Src: []string{"closed", "open"}, Dst: "broken"},
+			{Name: "break", Src: []string{AnyState}, Dst: "broken"},
+			{Name: "fix", Src: []string{"broken"}, Dst: "closed"},
+		},
+		Callbacks{},
+	)
+	expected := []TransitionInfo{
+		{"break", "*", "broken"},
+		{"kick", "closed", "broken"},
+		{"kick", "open", "broken"},
+	}
+	if incoming := fsm.IncomingTransitions("broken"); fmt.Sprint(incoming) != fmt.Sprint(expected) {
+		t.Errorf("expected %v, got %v", expected, incoming)
+	}
+	if incoming := fsm.IncomingTransitions("unknown"); len(incoming) != 0 {
+		t.Errorf("expected no incoming transitions, got %v", incoming)
+	}
+}
+
+func TestMultipleSources(t *testing.T) {
+	fsm := NewFSM(
+		"one",
+		Events{
+			{Name: "first", Src: []string{"one"}, Dst: "two"},
+			{Name: "second", Src: []string{"two"}, Dst: "three"},
+			{Name: "reset", Src: []string{"one", "two", "three"}, Dst: "one"},
+		},
+		Callbacks{},
+	)
+
+	fsm.Event("first")
+	if fsm.Current() != "two" {
+		t.Error("expected state to be 'two'")
+	}
+	fsm.Event("reset")
+	if fsm.Current() != "one" {
+		t.Error("expected state to be 'one'")
+	}
+	fsm.Event("first")
+	fsm.Event("second")
+	if fsm.Current() != "three" {
+		t.Error("expected state to be 'three'")
+	}
+	fsm.Event("reset")
+	if fsm.Current() != "one" {
+		t.Error("expected state to be 'one'")
+	}
+}
+
+func TestMultipleSourcesLeaveCallbacks(t *testing.T) {
+	left := make(map[string]bool)
+
+	fsm := NewFSM(
+		"one",
+		Events{
+			{Name: "first", Src: []string{"one"}, Dst: "two"},
+			{Name: "second", Src: []string{"two"}, Dst: "three"},
+			{Name: "reset", Src: []string{"one", "two", "three"}, Dst: "one"},
+		},
+		Callbacks{
+			"leave_two": func(e *Event) {
+				left[e.Src] = true
+			},
+			"leave_three": func(e *Event) {
+				left[e.Src] = true
+			},
+		},
+	)
+
+	fsm.Event("first")
+	fsm.Event("reset")
+	if !left["two"] {
+		t.Error("expected leave_two to be called on reset")
+	}
+	fsm.Event("first")
+	fsm.Event("second")
+	fsm.Event("reset")
+	if !left["three"] {
+		t.Error("expected leave_three to be called on reset")
+	}
+	if fsm.Current() != "one" {
+		t.Error("expected state to be 'one'")
+	}
+}
+
+func TestAnyStateSource(t *testing.T) {
+	fsm := NewFSM(
+		"one",
+		Events{
+			{Name: "first", Src: []string{"one"}, Dst: "two"},
+			{Name: "shutdown", Src: []string{"two"}, Dst: "one"},
+			{Name: "shutdown", Src: []string{AnyState}, Dst: "off"},
+		},
+		Callbacks{},
+	)
+
+	if !fsm.Can("shutdown") {
+		t.Error("expected shutdown to be possible from any state")
+	}
+	transitions := fsm.AvailableTransitions()
+	sort.Strings(transitions)
+	if len(transitions) != 2 || transitions[0] != "first" || transitions[1] != "shutdown" {
+		t.Errorf("expected [first shutdown], got %v", transitions)
+	}
+
+	fsm.Event("first")
+	if transitions := fsm.AvailableTransitions(); len(transitions) != 1 {
+		t.Errorf("expected a single shutdown transition, got %v", transitions)
+	}
+	fsm.Event("shutdown")
+	if fsm.Current() != "one" {
+		t.Error("expected the exact source to take precedence over AnyState")
+	}
+	fsm.Event("shutdown")
+	if fsm.Current() != "off" {
+		t.Error("expected state to be 'off'")
+	}
+}
+
+func TestMultipleEvents(t *testing.T) {
+	fsm := NewFSM(
+		"start",
+		Events{
+			{Name: "first", Src: []string{"start"}, Dst: "one"},
+			{Name: "second", Src: []string{"start"}, Dst: "two"},
+			{Name: "reset", Src: []string{"one"}, Dst: "reset_one"},
+			{Name: "reset", Src: []string{"two"}, Dst: "reset_two"},
+			{Name: "reset", Src: []string{"reset_one", "reset_two"}, Dst: "start"},
+		},
+		Callbacks{},
+	)
+
+	fsm.Event("first")
+	fsm.Event("reset")
+	if fsm.Current() != "reset_one" {
+		t.Error("expected state to be 'reset_one'")
+	}
+	fsm.Event("reset")
+	if fsm.Current() != "start" {
+		t.Error("expected state to be 'start'")
+	}
+
+	fsm.Event("second")
+	fsm.Event("reset")
+	if fsm.Current() != "reset_two" {
+		t.Error("expected state to be 'reset_two'")
+	}
+	fsm.Event("reset")
+	if fsm.Current() != "start" {
+		t.Error("expected state to be 'start'")
+	}
+}
+
+func TestIntStatesAndEvents(t *testing.T) {
+	type state int
+	type event int
+	const (
+		closed state = iota
+		open
+	)
+	const (
+		openDoor event = iota + 10
+		closeDoor
+	)
+	name := func(v interface{}) string {
+		return fmt.Sprint(v)
+	}
+
+	var entered []string
+	fsm := NewFSM(
+		name(closed),
+		Events{
+			{Name: name(openDoor), Src: []string{name(closed)}, Dst: name(open)},
+			{Name: name(closeDoor), Src: []string{name(open)}, Dst: name(closed)},
+		},
+		Callbacks{
+			"enter_" + name(open): func(e *Event) {
+				entered = append(entered, e.Event+":"+e.Src+">"+e.Dst)
+			},
+		},
+	)
+
+	if err := fsm.Event(name(openDoor)); err != nil {
+		t.Fatal(err)
+	}
+	if fsm.Current() != name(open) {
+		t.Errorf("expected state to be %d, got %s", open, fsm.Current())
+	}
+	if fmt.Sprint(entered) != "[10:0>1]" {
+		t.Errorf("expected the enter callback for state %d, got %v", open, entered)
+	}
+	if err := fsm.Event(name(openDoor)); err == nil {
+		t.Error("expected an error for an event not available in the state")
+	}
+	if err := fsm.Event(name(closeDoor)); err != nil {
+		t.Fatal(err)
+	}
+	if fsm.Current() != name(closed) {
+		t.Errorf("expected state to be %d, got %s", closed, fsm.Current())
+	}
+}
+
+func TestGuardPassed(t *testing.T) {
+	fsm := NewFSM(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end", Guard: func(e *Event) bool {
+				return len(e.Args) == 1 && e.Args[0] == "go"
+			}},
+		},
+		Callbacks{},
+	)
+	if err := fsm.Event("run", "go"); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+	if fsm.Current() != "end" {
+		t.Error("expected state to be 'end'")
+	}
+}
+
+func TestGuardFailed(t *testing.T) {
+	called := false
+	fsm := NewFSM(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end", Guard: func(e *Event) bool {
+				return false
+			}},
+		},
+		Callbacks{
+			"before_event": func(e *Event) {
+				called = true
+			},
+		},
+	)
+	err := fsm.Event("run")
+	if e, ok := err.(GuardFailedError); !ok || e.Event != "run" || e.State != "start" {
+		t.Errorf("expected 'GuardFailedError' with correct state and event, got %v", err)
+	}
+	if fsm.Current() != "start" {
+		t.Error("expected state to be 'start'")
+	}
+	if called {
+		t.Error("expected no callbacks to be called")
+	}
+}
+
+func TestGuardDisambiguation(t *testing.T) {
+	fsm := NewFSM(
+		"start",
+		Events{
+			{Name: "check", Src: []string{"start"}, Dst: "high", Guard: func(e *Event) bool {
+				return e.Args[0].(int) > 10
+			}},
+			{Name: "check", Src: []string{"start"}, Dst: "low"},
+			{Name: "reset", Src: []string{"high", "low"}, Dst: "start"},
+		},
+		Callbacks{},
+	)
+	fsm.Event("check", 20)
+	if fsm.Current() != "high" {
+		t.Error("expected state to be 'high'")
+	}
+	fsm.Event("reset")
+	fsm.Event("check", 5)
+	if fsm.Current() != "low" {
+		t.Error("expected state to be 'low'")
+	}
+}
+
+func TestGuardPrecedence(t *testing.T) {
+	var checked []string
+	guard := func(name string, pass bool) func(*Event) bool {
+		return func(e *Event) bool {
+			checked = append(checked, name)
+			return pass
+		}
+	}
+	fsm := NewFSM(
+		"start",
+		Events{
+			{Name: "check", Src: []string{"start"}, Dst: "first", Guard: guard("first", false)},
+			{Name: "check", Src: []string{"start"}, Dst: "second", Guard: guard("second", true)},
+			{Name: "check", Src: []string{"start"}, Dst: "third", Guard: guard("third", true)},
+			{Name: "check", Src: []string{"start"}, Dst: "fallback"},
+		},
+		Callbacks{},
+	)
+	if err := fsm.Event("check"); err != nil {
+		t.Fatal(err)
+	}
+	if fsm.Current() != "second" {
+		t.Errorf("expected state to be 'second', got %s", fsm.Current())
+	}
+	if fmt.Sprint(checked) != "[first second]" {
+		t.Errorf("expected guards [first second] to be checked in order, got %v", checked)
+	}
+}
+
+func TestGuardFallback(t *testing.T) {
+	fsm := NewFSM(
+		"start",
+		Events{
+			{Name: "check", Src: []string{"start"}, Dst: "first", Guard: func(e *Event) bool {
+				return false
+			}},
+			{Name: "check", Src: []string{"start"}, Dst: "second", Guard: func(e *Event) bool {
+				return false
+			}},
+			{Name: "check", Src: []string{"start"}, Dst: "fallback"},
+		},
+		Callbacks{},
+	)
+	if err := fsm.Event("check"); err != nil {
+		t.Fatal(err)
+	}
+	if fsm.Current() != "fallback" {
+		t.Errorf("expected state to be 'fallback', got %s", fsm.Current())
+	}
+}
+
+func TestGuardDefault(t *testing.T) {
+	fsm := NewFSM(
+		"start",
+		Events{
+			{Name: "check", Src: []string{"start"}, Dst: "default"},
+			{Name: "check", Src: []string{"start"}, Dst: "high", Guard: func(e *Event) bool {
+				return e.Args[0].(int) > 10
+			}},
+			{Name: "reset", Src: []string{"default", "high"}, Dst: "start"},
+		},
+		Callbacks{},
+	)
+	fsm.Event("check", 20)
+	if fsm.Current() != "high" {
+		t.Errorf("expected the guarded transition to be tried before the default, got %s", fsm.Current())
+	}
+	fsm.Event("reset")
+	fsm.Event("check", 5)
+	if fsm.Current() != "default" {
+		t.Errorf("expected the default transition to be taken, got %s", fsm.Current())
+	}
+}
+
+func TestGuardNoDefault(t *testing.T) {
+	fsm := NewFSM(
+		"start",
+		Events{
+			{Name: "check", Src: []string{"start"}, Dst: "high", Guard: func(e *Event) bool {
+				return e.Args[0].(int) > 10
+			}},
+			{Name: "check", Src: []string{"start"}, Dst: "low", Guard: func(e *Event) bool {
+				return e.Args[0].(int) < 0
+			}},
+		},
+		Callbacks{},
+	)
+	err := fsm.Event("check", 5)
+	if e, ok := err.(GuardFailedError); !ok || e.Event != "check" || e.State != "start" {
+		t.Errorf("expected 'GuardFailedError', got %v", err)
+	}
+	if fsm.Current() != "start" {
+		t.Error("expected state to be 'start'")
+	}
+}
+
+func TestChooseDestination(t *testing.T) {
+	fsm := NewFSM(
+		"start",
+		Events{
+			{Name: "route", Src: []string{"start"}, Choose: func(e *Event) string {
+				return e.Args[0].(string)
+			}},
+			{Name: "reset", Src: []string{"left", "right"}, Dst: "start"},
+		},
+		Callbacks{},
+	)
+	fsm.Event("route", "left")
+	if fsm.Current() != "left" {
+		t.Error("expected state to be 'left'")
+	}
+	fsm.Event("reset")
+	fsm.Event("route", "right")
+	if fsm.Current() != "right" {
+		t.Error("expected state to be 'right'")
+	}
+	fsm.Event("reset")
+	err := fsm.Event("route", "nowhere")
+	if e, ok := err.(InvalidDestinationError); !ok || e.Event != "route" || e.State != "nowhere" {
+		t.Errorf("expected 'InvalidDestinationError' with correct event and state, got %v", err)
+	}
+	if fsm.Current() != "start" {
+		t.Error("expected state to be 'start'")
+	}
+}
+
+func TestFireSequence(t *testing.T) {
+	fsm := NewFSM(
+		"green",
+		Events{
+			{Name: "warn", Src: []string{"green"}, Dst: "yellow"},
+			{Name: "panic", Src: []string{"yellow"}, Dst: "red"},
+			{Name: "calm", Src: []string{"red"}, Dst: "yellow"},
+			{Name: "clear", Src: []string{"yellow"}, Dst: "green"},
+		},
+		Callbacks{},
+	)
+	if err := fsm.FireSequence("warn", "panic", "calm", "clear"); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+	if fsm.Current() != "green" {
+		t.Error("expected state to be 'green'")
+	}
+}
+
+func TestFireSequenceFailed(t *testing.T) {
+	fsm := NewFSM(
+		"green",
+		Events{
+			{Name: "warn", Src: []string{"green"}, Dst: "yellow"},
+			{Name: "panic", Src: []string{"yellow"}, Dst: "red"},
+			{Name: "clear", Src: []string{"yellow"}, Dst: "green"},
+		},
+		Callbacks{},
+	)
+	err := fsm.FireSequence("warn", "panic", "clear", "warn")
+	e, ok := err.(SequenceError)
+	if !ok || e.Index != 2 || e.Event != "clear" {
+		t.Fatalf("expected 'SequenceError' at index 2, got %v", err)
+	}
+	if _, ok := e.Err.(InvalidEventError); !ok {
+		t.Errorf("expected the wrapped error to be 'InvalidEventError', got %v", e.Err)
+	}
+	if fsm.Current() != "red" {
+		t.Error("expected state to be 'red'")
+	}
+}
+
+func TestEventIf(t *testing.T) {
+	fsm := NewFSM(
+		"closed",
+		Events{
+			{Name: "open", Src: []string{"closed"}, Dst: "open"},
+			{Name: "close", Src: []string{"open"}, Dst: "closed"},
+			{Name: "lock", Src: []string{"closed"}, Dst: "locked"},
+		},
+		Callbacks{},
+	)
+
+	if err := fsm.EventIf("closed", "open"); err != nil {
+		t.Fatal(err)
+	}
+	if fsm.Current() != "open" {
+		t.Errorf("expected state to be 'open', got %s", fsm.Current())
+	}
+
+	err := fsm.EventIf("closed", "close")
+	if e, ok := err.(UnexpectedStateError); !ok || e.Expected != "closed" || e.Actual != "open" {
+		t.Errorf("expected UnexpectedStateError, got %v", err)
+	}
+	if fsm.Current() != "open" {
+		t.Errorf("expected state to still be 'open', got %s", fsm.Current())
+	}
+}
+
+func TestEventIfConcurrent(t *testing.T) {
+	fsm := NewFSM(
+		"closed",
+		Events{
+			{Name: "open", Src: []string{"closed"}, Dst: "open"},
+			{Name: "lock", Src: []string{"closed"}, Dst: "locked"},
+		},
+		Callbacks{},
+	)
+
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	for i, event := range []string{"open", "lock"} {
+		wg.Add(1)
+		go func(i int, event string) {
+			defer wg.Done()
+			errs[i] = fsm.EventIf("closed", event)
+		}(i, event)
+	}
+	wg.Wait()
+
+	failed := 0
+	for _, err := range errs {
+		if err == nil {
+			continue
+		}
+		failed++
+		if e, ok := err.(UnexpectedStateError); !ok || e.Expected != "closed" || e.Actual != fsm.Current() {
+			t.Errorf("expected UnexpectedStateError, got %v", err)
+		}
+	}
+	if failed != 1 {
+		t.Errorf("expected exactly one event to fail, got %v", errs)
+	}
+}
+
+func TestEventIfKeyNormalizer(t *testing.T) {
+	fsm := NewFSM(
+		"closed",
+		Events{
+			{Name: "open", Src: []string{"closed"}, Dst: "open"},
+		},
+		Callbacks{},
+		WithKeyNormalizer(strings.ToLower),
+	)
+
+	if err := fsm.EventIf("Closed", "Open"); err != nil {
+		t.Fatal(err)
+	}
+	if fsm.Current() != "open" {
+		t.Errorf("expected state to be 'open', got %s", fsm.Current())
+	}
+}
+
+func TestWithKeyNormalizer(t *testing.T) {
+	entered := false
+	fsm := NewFSM(
+		" Closed",
+		Events{
+			{Name: "Open", Src: []string{"closed"}, Dst: "OPEN "},
+			{Name: "close", Src: []string{"Open"}, Dst: "Closed"},
+		},
+		Callbacks{
+			"enter_Open": func(e *Event) {
+				entered = true
+			},
+		},
+		WithKeyNormalizer(func(s string) string {
+			return strings.ToLower(strings.TrimSpace(s))
+		}),
+	)
+
+	if fsm.Current() != "closed" {
+		t.Errorf("expected the initial state to be normalized to 'closed', got %s", fsm.Current())
+	}
+	if !fsm.Can("open") || !fsm.Can(" Open") {
+		t.Error("expected 'open' and ' Open' to resolve to the same event")
+	}
+	if err := fsm.Event("open"); err != nil {
+		t.Fatal(err)
+	}
+	if fsm.Current() != "open" {
+		t.Errorf("expected state to be 'open', got %s", fsm.Current())
+	}
+	if !entered {
+		t.Error("expected the enter_Open callback to be called")
+	}
+	if err := fsm.Event("CLOSE"); err != nil {
+		t.Fatal(err)
+	}
+	if fsm.Current() != "closed" {
+		t.Errorf("expected state to be 'closed', got %s", fsm.Current())
+	}
+	if !fsm.Is("Closed") {
+		t.Error("expected Is to normalize the state")
+	}
+	if !fsm.HasEnterCallback("OPEN") {
+		t.Error("expected HasEnterCallback to normalize the state")
+	}
+
+	var before, left bool
+	fsm.OnBeforeEvent("OPEN", func(e *Event) {
+		before = true
+	})
+	fsm.OnLeave(" Closed ", func(e *Event) {
+		left = true
+	})
+	if !fsm.HasBeforeCallback("open") || !fsm.HasLeaveCallback("closed") {
+		t.Error("expected the callbacks to be set for the normalized names")
+	}
+	if err := fsm.Event("open"); err != nil {
+		t.Fatal(err)
+	}
+	if !before || !left {
+		t.Error("expected the callbacks set with OnBeforeEvent and OnLeave to be called")
+	}
+
+	if err := fsm.RestoreState("CLOSED"); err != nil {
+		t.Fatal(err)
+	}
+	if fsm.Current() != "closed" {
+		t.Errorf("expected RestoreState to move to 'closed', got %s", fsm.Current())
+	}
+	fsm.SetState(" Open")
+	if fsm.Current() != "open" {
+		t.Errorf("expected SetState to move to 'open', got %s", fsm.Current())
+	}
+	if err := fsm.WaitForState(context.Background(), "OPEN"); err != nil {
+		t.Error(err)
+	}
+	fsm.SetFinalStates("Open")
+	if !fsm.IsFinal() {
+		t.Error("expected SetFinalStates to normalize the state")
+	}
+}
+
+func TestEventAliases(t *testing.T) {
+	var events []string
+	fsm := NewFSM(
+		"running",
+		Events{
+			{Name: "stop", Src: []string{"running"}, Dst: "stopped", Aliases: []string{"halt", "abort"}},
+			{Name: "start", Src: []string{"stopped"}, Dst: "running"},
+		},
+		Callbacks{
+			"before_stop": func(e *Event) {
+				events = append(events, e.Event)
+			},
+		},
+	)
+	for _, alias := range []string{"stop", "halt", "abort"} {
+		if !fsm.Can(alias) {
+			t.Errorf("expected %s to be possible", alias)
+		}
+		if err := fsm.Event(alias); err != nil {
+			t.Errorf("expected no error for %s, got %v", alias, err)
+		}
+		if fsm.Current() != "stopped" {
+			t.Errorf("expected %s to stop", alias)
+		}
+		fsm.Event("start")
+	}
+	if fmt.Sprint(events) != "[stop stop stop]" {
+		t.Errorf("expected callbacks to be called with the event name, got %v", events)
+	}
+	if aliases := fsm.Aliases("stop"); fmt.Sprint(aliases) != "[abort halt]" {
+		t.Errorf("expected [abort halt], got %v", aliases)
+	}
+	if events := fsm.Events(); fmt.Sprint(events) != "[start stop]" {
+		t.Errorf("expected [start stop], got %v", events)
+	}
+}
+
+func TestGenericCallbacks(t *testing.T) {
+	beforeEvent := false
+	leaveState := false
+	enterState := false
+	afterEvent := false
+
+	fsm := NewFSM(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+		},
+		Callbacks{
+			"before_event": func(e *Event) {
+				beforeEvent = true
+			},
+			"leave_state": func(e *Event) {
+				leaveState = true
+			},
+			"enter_state": func(e *Event) {
+				enterState = true
+			},
+			"after_event": func(e *Event) {
+				afterEvent = true
+			},
+		},
+	)
+
+	fsm.Event("run")
+	if !(beforeEvent && leaveState && enterState && afterEvent) {
+		t.Error("expected all callbacks to be called")
+	}
+}
+
+func TestSpecificCallbacks(t *testing.T) {
+	beforeEvent := false
+	leaveState := false
+	enterState := false
+	afterEvent := false
+
+	fsm := NewFSM(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+		},
+		Callbacks{
+			"before_run": func(e *Event) {
+				beforeEvent = true
+			},
+			"leave_start": func(e *Event) {
+				leaveState = true
+			},
+			"enter_end": func(e *Event) {
+				enterState = true
+			},
+			"after_run": func(e *Event) {
+				afterEvent = true
+			},
+		},
+	)
+
+	fsm.Event("run")
+	if !(beforeEvent && leaveState && enterState && afterEvent) {
+		t.Error("expected all callbacks to be called")
+	}
+}
+
+func TestOnEnterOnLeave(t *testing.T) {
+	var calls []string
+
+	fsm := NewFSM(
+		"green",
+		Events{
+			{Name: "warn", Src: []string{"green"}, Dst: "yellow"},
+			{Name: "panic", Src: []string{"green", "yellow"}, Dst: "red"},
+			{Name: "calm", Src: []string{"red"}, Dst: "yellow"},
+		},
+		Callbacks{
+			"enter_state": func(e *Event) {
+				calls = append(calls, "enter_state")
+			},
+		},
+	)
+	fsm.OnEnter("red", func(e *Event) {
+		calls = append(calls, "enter_red:"+e.Event)
+	})
+	fsm.OnLeave("red", func(e *Event) {
+		calls = append(calls, "leave_red:"+e.Event)
+	})
+
+	fsm.Event("panic")
+	fsm.Event("calm")
+	fsm.Event("panic")
+
+	expected := []string{
+		"enter_red:panic", "enter_state",
+		"leave_red:calm", "enter_state",
+		"enter_red:panic", "enter_state",
+	}
+	if fmt.Sprint(calls) != fmt.Sprint(expected) {
+		t.Errorf("expected %v, got %v", expected, calls)
+	}
+}
+
+func TestOnEnterAnyOnLeaveAny(t *testing.T) {
+	var calls []string
+
+	fsm := NewFSM(
+		"ok",
+		Events{
+			{Name: "timeout", Src: []string{"ok"}, Dst: "timed_out"},
+			{Name: "crash", Src: []string{"ok"}, Dst: "crashed"},
+			{Name: "reject", Src: []string{"ok"}, Dst: "rejected"},
+			{Name: "recover", Src: []string{"timed_out", "crashed", "rejected"}, Dst: "ok"},
+		},
+		Callbacks{
+			"enter_state": func(e *Event) {
+				calls = append(calls, "enter_state")
+			},
+		},
+	)
+	errorStates := []string{"timed_out", "crashed", "rejected", "crashed"}
+	fsm.OnEnterAny(errorStates, func(e *Event) {
+		calls = append(calls, "alert:"+e.Dst)
+	})
+	fsm.OnLeaveAny(errorStates, func(e *Event) {
+		calls = append(calls, "clear:"+e.Src)
+	})
+
+	for _, event := range []string{"timeout", "recover", "crash", "recover", "reject"} {
+		if err := fsm.Event(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := []string{
+		"alert:timed_out", "enter_state",
+		"clear:timed_out", "enter_state",
+		"alert:crashed", "enter_state",
+		"clear:crashed", "enter_state",
+		"alert:rejected", "enter_state",
+	}
+	if fmt.Sprint(calls) != fmt.Sprint(expected) {
+		t.Errorf("expected %v, got %v", expected, calls)
+	}
+}
+
+func TestLink(t *testing.T) {
+	worker := NewFSM(
+		"idle",
+		Events{
+			{Name: "start", Src: []string{"idle"}, Dst: "working"},
+		},
+		Callbacks{},
+	)
+	logger := &captureLogger{}
+	manager := NewFSM(
+		"waiting",
+		Events{
+			{Name: "prepare", Src: []string{"waiting"}, Dst: "ready"},
+			{Name: "reset", Src: []string{"ready"}, Dst: "waiting"},
+		},
+		Callbacks{},
+	)
+	manager.Link("ready", worker, "start", true)
+
+	if err := manager.Event("prepare"); err != nil {
+		t.Fatal(err)
+	}
+	if worker.Current() != "working" {
+		t.Errorf("expected the linked FSM to be 'working', got %s", worker.Current())
+	}
+
+	manager.Event("reset")
+	err := manager.Event("prepare")
+	if e, ok := err.(InvalidEventError); !ok || e.Event != "start" || e.State != "working" {
+		t.Errorf("expected the error of the linked FSM, got %v", err)
+	}
+
+	other := NewFSM(
+		"waiting",
+		Events{
+			{Name: "prepare", Src: []string{"waiting"}, Dst: "ready"},
+		},
+		Callbacks{},
+	)
+	other.SetLogger(logger)
+	other.Link("ready", worker, "start", false)
+	if err := other.Event("prepare"); err != nil {
+		t.Errorf("expected the error of the linked FSM to be ignored, got %v", err)
+	}
+	logged := false
+	for _, line := range logger.lines {
+		if line == "fsm: linked event start failed: event start inappropriate in current state working" {
+			logged = true
+		}
+	}
+	if !logged {
+		t.Errorf("expected the error to be logged, got %v", logger.lines)
+	}
+}
+
+func TestOnBeforeEventOnAfterEvent(t *testing.T) {
+	var calls []string
+
+	fsm := NewFSM(
+		"green",
+		Events{
+			{Name: "warn", Src: []string{"green"}, Dst: "yellow"},
+			{Name: "panic", Src: []string{"yellow"}, Dst: "red"},
+		},
+		Callbacks{},
+	)
+	fsm.OnBeforeEvent("warn", func(e *Event) {
+		calls = append(calls, "before_warn")
+	})
+	fsm.OnAfterEvent("panic", func(e *Event) {
+		calls = append(calls, "after_panic")
+	})
+
+	fsm.Event("warn")
+	fsm.Event("panic")
+
+	if fmt.Sprint(calls) != "[before_warn after_panic]" {
+		t.Errorf("expected [before_warn after_panic], got %v", calls)
+	}
+}
+
+func TestHasCallback(t *testing.T) {
+	fsm := NewFSM(
+		"green",
+		Events{
+			{Name: "warn", Src: []string{"green"}, Dst: "yellow"},
+			{Name: "panic", Src: []string{"yellow"}, Dst: "red"},
+		},
+		Callbacks{
+			"before_warn": func(e *Event) {},
+			"leave_green": func(e *Event) {},
+			"red":         func(e *Event) {},
+			"after_event": func(e *Event) {},
+		},
+	)
+	if !fsm.HasBeforeCallback("warn") || fsm.HasBeforeCallback("panic") || fsm.HasBeforeCallback("") {
+		t.Error("expected only a before_warn callback")
+	}
+	if !fsm.HasLeaveCallback("green") || fsm.HasLeaveCallback("yellow") || fsm.HasLeaveCallback("") {
+		t.Error("expected only a leave_green callback")
+	}
+	if !fsm.HasEnterCallback("red") || fsm.HasEnterCallback("yellow") || fsm.HasEnterCallback("") {
+		t.Error("expected only an enter_red callback")
+	}
+	if !fsm.HasAfterCallback("") || fsm.HasAfterCallback("warn") {
+		t.Error("expected only an after_event callback")
+	}
+	fsm.OnEnter("yellow", func(e *Event) {})
+	if !fsm.HasEnterCallback("yellow") {
+		t.Error("expected an enter_yellow callback")
+	}
+}
+
+func TestAddHooks(t *testing.T) {
+	var calls []string
+	record := func(name string) Callback {
+		return func(e *Event) {
+			calls = append(calls, name)
+		}
+	}
+	fsm := NewFSM(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+		},
+		Callbacks{
+			"before_event": record("before_event"),
+			"enter_state":  record("enter_state"),
+		},
+	)
+	fsm.AddBeforeEvent(record("before_1"))
+	fsm.AddBeforeEvent(record("before_2"))
+	fsm.AddLeaveState(record("leave_1"))
+	fsm.AddEnterState(record("enter_1"))
+	fsm.AddAfterEvent(record("after_1"))
+	fsm.Event("run")
+
+	expected := []string{"before_event", "before_1", "before_2", "leave_1", "enter_state", "enter_1", "after_1"}
+	if fmt.Sprint(calls) != fmt.Sprint(expected) {
+		t.Errorf("expected %v, got %v", expected, calls)
+	}
+}
+
+func TestAddHooksCancel(t *testing.T) {
+	called := false
+	fsm := NewFSM(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+		},
+		Callbacks{},
+	)
+	fsm.AddBeforeEvent(func(e *Event) {
+		e.Cancel(fmt.Errorf("invalid"))
+	})
+	fsm.AddBeforeEvent(func(e *Event) {
+		called = true
+	})
+	err := fsm.Event("run")
+	if e, ok := err.(CanceledError); !ok || e.Err.Error() != "invalid" {
+		t.Errorf("expected 'CanceledError' with the hook's error, got %v", err)
+	}
+	if called {
+		t.Error("expected the remaining hooks not to be called")
+	}
+	if fsm.Current() != "start" {
+		t.Error("expected state to be 'start'")
+	}
+}
+
+func TestSpecificCallbacksShortform(t *testing.T) {
+	enterState := false
+	afterEvent := false
+
+	fsm := NewFSM(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+		},
+		Callbacks{
+			"end": func(e *Event) {
+				enterState = true
+			},
+			"run": func(e *Event) {
+				afterEvent = true
+			},
+		},
+	)
+
+	fsm.Event("run")
+	if !(enterState && afterEvent) {
+		t.Error("expected all callbacks to be called")
+	}
+}
+
+func TestSpecificCallbacksShortformPrecedence(t *testing.T) {
+	var calls []string
+
+	fsm := NewFSM(
+		"idle",
+		Events{
+			{Name: "open", Src: []string{"idle"}, Dst: "open"},
+			{Name: "close", Src: []string{"open"}, Dst: "idle"},
+		},
+		Callbacks{
+			"open": func(e *Event) {
+				calls = append(calls, "open:"+e.Event)
+			},
+			"unknown": func(e *Event) {
+				calls = append(calls, "unknown")
+			},
+			"enter_state": func(e *Event) {
+				calls = append(calls, "enter_state:"+e.Event)
+			},
+			"after_event": func(e *Event) {
+				calls = append(calls, "after_event:"+e.Event)
+			},
+		},
+	)
+
+	fsm.Event("open")
+	fsm.Event("close")
+	// An after_open callback would run after enter_state.
+	if fmt.Sprint(calls) != "[open:open enter_state:open after_event:open enter_state:close after_event:close]" {
+		t.Errorf("expected the short form to be an enter_open callback, got %v", calls)
+	}
+}
+
+func TestBeforeEventWithoutTransition(t *testing.T) {
+	beforeEvent := true
+
+	fsm := NewFSM(
+		"start",
+		Events{
+			{Name: "dontrun", Src: []string{"start"}, Dst: "start"},
+		},
+		Callbacks{
+			"before_event": func(e *Event) {
+				beforeEvent = true
+			},
+		},
+	)
+
+	err := fsm.Event("dontrun")
+	if e, ok := err.(NoTransitionError); !ok && e.Err != nil {
+		t.Error("expected 'NoTransitionError' without custom error")
+	}
+
+	if fsm.Current() != "start" {
+		t.Error("expected state to be 'start'")
+	}
+	if !beforeEvent {
+		t.Error("expected callback to be called")
+	}
+}
+
+func TestCancelBeforeGenericEvent(t *testing.T) {
+	fsm := NewFSM(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+		},
+		Callbacks{
+			"before_event": func(e *Event) {
+				e.Cancel()
+			},
+		},
+	)
+	fsm.Event("run")
+	if fsm.Current() != "start" {
+		t.Error("expected state to be 'start'")
+	}
+}
+
+func TestCancelBeforeSpecificEvent(t *testing.T) {
+	fsm := NewFSM(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+		},
+		Callbacks{
+			"before_run": func(e *Event) {
+				e.Cancel()
+			},
+		},
+	)
+	fsm.Event("run")
+	if fsm.Current() != "start" {
+		t.Error("expected state to be 'start'")
+	}
+}
+
+func TestCancelLeaveGenericState(t *testing.T) {
+	fsm := NewFSM(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+		},
+		Callbacks{
+			"leave_state": func(e *Event) {
+				e.Cancel()
+			},
+		},
+	)
+	fsm.Event("run")
+	if fsm.Current() != "start" {
+		t.Error("expected state to be 'start'")
+	}
+}
+
+func TestCancelLeaveSpecificState(t *testing.T) {
+	fsm := NewFSM(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+		},
+		Callbacks{
+			"leave_start": func(e *Event) {
+				e.Cancel()
+			},
+		},
+	)
+	fsm.Event("run")
+	if fsm.Current() != "start" {
+		t.Error("expected state to be 'start'")
+	}
+}
+
+func TestCancelWithError(t *testing.T) {
+	fsm := NewFSM(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+		},
+		Callbacks{
+			"before_event": func(e *Event) {
+				e.Cancel(fmt.Errorf("error"))
+			},
+		},
+	)
+	err := fsm.Event("run")
+	if _, ok := err.(CanceledError); !ok {
+		t.Error("expected only 'CanceledError'")
+	}
+
+	if e, ok := err.(CanceledError); ok && e.Err.Error() != "error" {
+		t.Error("expected 'CanceledError' with correct custom error")
+	}
+
+	if fsm.Current() != "start" {
+		t.Error("expected state to be 'start'")
+	}
+}
+
+func TestAsyncTransitionGenericState(t *testing.T) {
+	fsm := NewFSM(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+		},
+		Callbacks{
+			"leave_state": func(e *Event) {
+				e.Async()
+			},
+		},
+	)
+	fsm.Event("run")
+	if fsm.Current() != "start" {
+		t.Error("expected state to be 'start'")
+	}
+	fsm.Transition()
+	if fsm.Current() != "end" {
+		t.Error("expected state to be 'end'")
+	}
+}
+
+func TestAsyncTransitionSpecificState(t *testing.T) {
+	fsm := NewFSM(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+		},
+		Callbacks{
+			"leave_start": func(e *Event) {
+				e.Async()
+			},
+		},
+	)
+	fsm.Event("run")
+	if fsm.Current() != "start" {
+		t.Error("expected state to be 'start'")
+	}
+	fsm.Transition()
+	if fsm.Current() != "end" {
+		t.Error("expected state to be 'end'")
+	}
+}
+
+func TestTransitionWithArgs(t *testing.T) {
+	var args []interface{}
+	fsm := NewFSM(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+		},
+		Callbacks{
+			"leave_start": func(e *Event) {
+				e.Async()
+			},
+			"enter_end": func(e *Event) {
+				args = e.Args
+			},
+		},
+	)
+	fsm.Event("run", "event")
+	if err := fsm.TransitionWithArgs("transition", 1); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+	if fmt.Sprint(args) != "[event transition 1]" {
+		t.Errorf("expected the combined arguments, got %v", args)
+	}
+	if _, ok := fsm.TransitionWithArgs("again").(NotInTransitionError); !ok {
+		t.Error("expected 'NotInTransitionError'")
+	}
+}
+
+func TestTransitionWithContext(t *testing.T) {
+	type key struct{}
+	var leaveValue, enterValue interface{}
+	fsm := NewFSM(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+		},
+		Callbacks{
+			"leave_start": func(e *Event) {
+				leaveValue = e.Context().Value(key{})
+				e.Async()
+			},
+			"enter_end": func(e *Event) {
+				enterValue = e.Context().Value(key{})
+			},
+		},
+	)
+	fsm.EventWithContext(context.WithValue(context.Background(), key{}, "event"), "run")
+
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), key{}, "transition"))
+	cancel()
+	if err := fsm.TransitionWithContext(ctx); err != context.Canceled {
+		t.Errorf("expected 'context.Canceled', got %v", err)
+	}
+	if fsm.Current() != "start" || !fsm.InTransition() {
+		t.Error("expected the transition to still be pending")
+	}
+	if enterValue != nil {
+		t.Error("expected enter_end not to be called")
+	}
+
+	ctx = context.WithValue(context.Background(), key{}, "transition")
+	if err := fsm.TransitionWithContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if fsm.Current() != "end" {
+		t.Error("expected state to be 'end'")
+	}
+	if leaveValue != "event" || enterValue != "transition" {
+		t.Errorf("expected the event and transition contexts, got %v and %v", leaveValue, enterValue)
+	}
+}
+
+func TestCallbackTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	fsm := NewFSM(
+		"start",
+		Events{
+			{Name: "slow", Src: []string{"start"}, Dst: "end"},
+			{Name: "fast", Src: []string{"start"}, Dst: "end"},
+			{Name: "enter", Src: []string{"end"}, Dst: "slow_enter"},
+		},
+		Callbacks{
+			"before_slow": func(e *Event) {
+				<-release
+			},
+			"enter_slow_enter": func(e *Event) {
+				<-release
+			},
+		},
+	)
+	fsm.SetCallbackTimeout(20 * time.Millisecond)
+
+	err := fsm.Event("slow")
+	if e, ok := err.(CallbackTimeoutError); !ok || e.Event != "slow" || e.Phase != "before" {
+		t.Errorf("expected 'CallbackTimeoutError' in the before callbacks, got %v", err)
+	}
+	if fsm.Current() != "start" || fsm.InTransition() {
+		t.Error("expected state to be 'start' without a pending transition")
+	}
+
+	if err := fsm.Event("fast"); err != nil {
+		t.Errorf("expected no error within the timeout, got %v", err)
+	}
+	if fsm.Current() != "end" {
+		t.Error("expected state to be 'end'")
+	}
+
+	err = fsm.Event("enter")
+	if e, ok := err.(CallbackTimeoutError); !ok || e.Phase != "enter" {
+		t.Errorf("expected 'CallbackTimeoutError' in the enter callbacks, got %v", err)
+	}
+	if fsm.Current() != "slow_enter" || fsm.InTransition() {
+		t.Error("expected state to be 'slow_enter' without a pending transition")
+	}
+}
+
+// chanLogger sends the logged lines to a channel.
+type chanLogger chan string
+
+func (l chanLogger) Logf(format string, args ...interface{}) {
+	l <- fmt.Sprintf(format, args...)
+}
+
+func TestCallbackTimeoutLateCallbacks(t *testing.T) {
+	release := make(chan struct{})
+	fsm := NewFSM(
+		"start",
+		Events{
+			{Name: "slow", Src: []string{"start"}, Dst: "end"},
+		},
+		Callbacks{
+			"before_slow": func(e *Event) {
+				<-release
+				e.Cancel()
+			},
+		},
+	)
+	logger := make(chanLogger, 10)
+	fsm.SetLogger(logger)
+	fsm.SetCallbackTimeout(20 * time.Millisecond)
+
+	if _, ok := fsm.Event("slow").(CallbackTimeoutError); !ok {
+		t.Fatal("expected 'CallbackTimeoutError'")
+	}
+
+	// The timed out callback keeps running while the FSM is changed.
+	close(release)
+	fsm.SetLogger(nil)
+	fsm.OnBeforeEvent("slow", func(e *Event) {})
+	fsm.AddBeforeEvent(func(e *Event) {})
+
+	for {
+		select {
+		case line := <-logger:
+			if strings.HasPrefix(line, "fsm: canceled") {
+				return
+			}
+		case <-time.After(time.Second):
+			t.Fatal("expected the timed out callback to log the cancellation")
+		}
+	}
+}
+
+func TestCallbackTimeoutPanic(t *testing.T) {
+	fsm := NewFSM(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+		},
+		Callbacks{
+			"before_run": func(e *Event) {
+				panic("boom")
+			},
+		},
+	)
+	fsm.SetCallbackTimeout(time.Second)
+	fsm.SetRecoverPanics(true)
+	err := fsm.Event("run")
+	if e, ok := err.(CallbackPanicError); !ok || e.Value != "boom" {
+		t.Errorf("expected 'CallbackPanicError', got %v", err)
+	}
+}
+
+func TestAsyncTransitionInProgress(t *testing.T) {
+	fsm := NewFSM(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+			{Name: "reset", Src: []string{"end"}, Dst: "start"},
+		},
+		Callbacks{
+			"leave_start": func(e *Event) {
+				e.Async()
+			},
+		},
+	)
+	fsm.Event("run")
+	err := fsm.Event("reset")
+	if e, ok := err.(InTransitionError); !ok && e.Event != "reset" {
+		t.Error("expected 'InTransitionError' with correct state")
+	}
+	fsm.Transition()
+	fsm.Event("reset")
+	if fsm.Current() != "start" {
+		t.Error("expected state to be 'start'")
+	}
+}
+
+func TestAsyncTransitionInProgressStates(t *testing.T) {
+	fsm := NewFSM(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+		},
+		Callbacks{
+			"leave_start": func(e *Event) {
+				e.Async()
+			},
+		},
+	)
+	fsm.Event("run")
+	err := fsm.Event("run")
+	e, ok := err.(InTransitionError)
+	if !ok || e.Event != "run" || e.Src != "start" || e.Dst != "end" {
+		t.Errorf("expected 'InTransitionError' with the pending states, got %v", err)
+	}
+}
+
+func TestInTransition(t *testing.T) {
+	fsm := NewFSM(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+		},
+		Callbacks{
+			"leave_start": func(e *Event) {
+				e.Async()
+			},
+		},
+	)
+	if fsm.InTransition() {
+		t.Error("expected no transition initially")
+	}
+	fsm.Event("run")
+	if !fsm.InTransition() {
+		t.Error("expected a transition after an async event")
+	}
+	fsm.Transition()
+	if fsm.InTransition() {
+		t.Error("expected no transition after completing it")
+	}
+}
+
+func TestAsyncTransitionNotInProgress(t *testing.T) {
+	fsm := NewFSM(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+			{Name: "reset", Src: []string{"end"}, Dst: "start"},
+		},
+		Callbacks{},
+	)
+	err := fsm.Transition()
+	if _, ok := err.(NotInTransitionError); !ok {
+		t.Error("expected 'NotInTransitionError'")
+	}
+}
+
+func TestPeek(t *testing.T) {
+	fsm := NewFSM(
+		"closed",
+		Events{
+			{Name: "open", Src: []string{"closed"}, Dst: "open"},
+			{Name: "close", Src: []string{"open"}, Dst: "closed"},
+		},
+		Callbacks{
+			"leave_closed": func(e *Event) {
+				e.Async()
+			},
+		},
+	)
+	if dst, ok := fsm.Peek("open"); !ok || dst != "open" {
+		t.Errorf("expected 'open', got %q %v", dst, ok)
+	}
+	if _, ok := fsm.Peek("close"); ok {
+		t.Error("expected close to be invalid")
+	}
+	if _, ok := fsm.Peek("lock"); ok {
+		t.Error("expected lock to be invalid")
+	}
+	fsm.Event("open")
+	if _, ok := fsm.Peek("close"); ok {
+		t.Error("expected no events during async transition")
+	}
+	if fsm.Current() != "closed" {
+		t.Error("expected Peek not to change the state")
+	}
+}
+
+func TestAvailableTransitions(t *testing.T) {
+	fsm := NewFSM(
+		"closed",
+		Events{
+			{Name: "open", Src: []string{"closed"}, Dst: "open"},
+			{Name: "kick", Src: []string{"closed"}, Dst: "broken"},
+			{Name: "close", Src: []string{"open"}, Dst: "closed"},
+		},
+		Callbacks{
+			"leave_closed": func(e *Event) {
+				e.Async()
+			},
+		},
+	)
+	transitions := fsm.AvailableTransitions()
+	sort.Strings(transitions)
+	if len(transitions) != 2 || transitions[0] != "kick" || transitions[1] != "open" {
+		t.Errorf("expected [kick open], got %v", transitions)
+	}
+	fsm.Event("open")
+	if transitions := fsm.AvailableTransitions(); len(transitions) != 0 {
+		t.Errorf("expected no transitions during async transition, got %v", transitions)
+	}
+	fsm.Transition()
+	transitions = fsm.AvailableTransitions()
+	if len(transitions) != 1 || transitions[0] != "close" {
+		t.Errorf("expected [close], got %v", transitions)
+	}
+}
+
+func TestAvailableTransitionsSnapshot(t *testing.T) {
+	fsm := NewFSM(
+		"off",
+		Events{
+			{Name: "turn_on", Src: []string{"off"}, Dst: "on"},
+			{Name: "turn_off", Src: []string{"on"}, Dst: "off"},
+		},
+		Callbacks{},
+	)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 500; i++ {
+			fsm.Event("turn_on")
+			fsm.Event("turn_off")
+		}
+	}()
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		state, transitions := fsm.AvailableTransitionsSnapshot()
+		if len(transitions) == 0 {
+			// An event is being processed.
+			continue
+		}
+		if len(transitions) != 1 || transitions[0] != "turn_"+map[string]string{"off": "on", "on": "off"}[state] {
+			t.Fatalf("transitions %v do not match state %s", transitions, state)
+		}
+	}
+}
+
+func TestAvailableTransitionsAnyState(t *testing.T) {
+	fsm := NewFSM(
+		"closed",
+		Events{
+			{Name: "open", Src: []string{"closed"}, Dst: "open"},
+			{Name: "reset", Src: []string{AnyState}, Dst: "closed"},
+			{Name: "kick", Src: []string{AnyState}, Dst: "broken"},
+			{Name: "kick", Src: []string{"broken"}, Dst: "broken"},
+		},
+		Callbacks{},
+	)
+	if transitions := fsm.AvailableTransitions(); fmt.Sprint(transitions) != "[kick open reset]" {
+		t.Errorf("expected [kick open reset], got %v", transitions)
+	}
+	fsm.SetState("broken")
+	if transitions := fsm.AvailableTransitions(); fmt.Sprint(transitions) != "[kick reset]" {
+		t.Errorf("expected [kick reset], got %v", transitions)
+	}
+	fsm.SetState("undefined")
+	if transitions := fsm.AvailableTransitions(); fmt.Sprint(transitions) != "[kick reset]" {
+		t.Errorf("expected [kick reset], got %v", transitions)
+	}
+}
+
+func TestEventWithContextCanceledBeforeEvent(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	fsm := NewFSM(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+		},
+		Callbacks{
+			"before_run": func(e *Event) {
+				cancel()
+			},
+		},
+	)
+	err := fsm.EventWithContext(ctx, "run")
+	if err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if fsm.Current() != "start" {
+		t.Error("expected state to be 'start'")
+	}
+}
+
+func TestEventWithContextCanceledLeaveState(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	fsm := NewFSM(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+		},
+		Callbacks{
+			"leave_start": func(e *Event) {
+				cancel()
+			},
+		},
+	)
+	err := fsm.EventWithContext(ctx, "run")
+	if err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if fsm.Current() != "start" {
+		t.Error("expected state to be 'start'")
+	}
+	if _, ok := fsm.Transition().(NotInTransitionError); !ok {
+		t.Error("expected the aborted transition to be cleared")
+	}
+}
+
+func TestEventWithContextDone(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	<-ctx.Done()
+
+	called := false
+	fsm := NewFSM(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+		},
+		Callbacks{
+			"before_event": func(e *Event) {
+				called = true
+			},
+		},
+	)
+	err := fsm.EventWithContext(ctx, "run")
+	if err != context.DeadlineExceeded {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+	if called {
+		t.Error("expected no callbacks to be called")
+	}
+}
+
+func TestEventContext(t *testing.T) {
+	type ctxKey struct{}
+	ctx := context.WithValue(context.Background(), ctxKey{}, "value")
+
+	var value interface{}
 	fsm := NewFSM(
 		"start",
 		Events{
-			{Name: "run", Src: []string{"start"}, Dst: "running"},
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+		},
+		Callbacks{
+			"enter_end": func(e *Event) {
+				value = e.Context().Value(ctxKey{})
+			},
 		},
-		Callbacks{},
 	)
-	fsm.transitionerObj = new(fakeTransitionerObj)
-	err := fsm.Event("run")
-	if err == nil {
-		t.Error("bad transition should give an error")
+	if err := fsm.EventWithContext(ctx, "run"); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+	if value != "value" {
+		t.Error("expected the context to be passed to the callback")
 	}
 }
 
-func TestInappropriateEvent(t *testing.T) {
+func TestStates(t *testing.T) {
 	fsm := NewFSM(
 		"closed",
 		Events{
 			{Name: "open", Src: []string{"closed"}, Dst: "open"},
 			{Name: "close", Src: []string{"open"}, Dst: "closed"},
+			{Name: "kick", Src: []string{"closed", "open"}, Dst: "broken"},
+			{Name: "shutdown", Src: []string{AnyState}, Dst: "off"},
 		},
 		Callbacks{},
 	)
-	err := fsm.Event("close")
-	if e, ok := err.(InvalidEventError); !ok && e.Event != "close" && e.State != "closed" {
-		t.Error("expected 'InvalidEventError' with correct state and event")
+	states := fsm.States()
+	expected := []string{"broken", "closed", "off", "open"}
+	if fmt.Sprint(states) != fmt.Sprint(expected) {
+		t.Errorf("expected %v, got %v", expected, states)
 	}
 }
 
-func TestInvalidEvent(t *testing.T) {
+func TestEvents(t *testing.T) {
 	fsm := NewFSM(
 		"closed",
 		Events{
 			{Name: "open", Src: []string{"closed"}, Dst: "open"},
 			{Name: "close", Src: []string{"open"}, Dst: "closed"},
+			{Name: "kick", Src: []string{"closed", "open"}, Dst: "broken"},
 		},
 		Callbacks{},
 	)
-	err := fsm.Event("lock")
-	if e, ok := err.(UnknownEventError); !ok && e.Event != "close" {
-		t.Error("expected 'UnknownEventError' with correct event")
+	events := fsm.Events()
+	expected := []string{"close", "kick", "open"}
+	if fmt.Sprint(events) != fmt.Sprint(expected) {
+		t.Errorf("expected %v, got %v", expected, events)
 	}
 }
 
-func TestMultipleSources(t *testing.T) {
+func TestCancelTransition(t *testing.T) {
 	fsm := NewFSM(
-		"one",
+		"start",
 		Events{
-			{Name: "first", Src: []string{"one"}, Dst: "two"},
-			{Name: "second", Src: []string{"two"}, Dst: "three"},
-			{Name: "reset", Src: []string{"one", "two", "three"}, Dst: "one"},
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+		},
+		Callbacks{
+			"leave_start": func(e *Event) {
+				e.Async()
+			},
+			"enter_end": func(e *Event) {
+				t.Error("expected no callbacks after canceling")
+			},
 		},
-		Callbacks{},
 	)
-
-	fsm.Event("first")
-	if fsm.Current() != "two" {
-		t.Error("expected state to be 'two'")
+	fsm.Event("run")
+	if err := fsm.CancelTransition(); err != nil {
+		t.Errorf("expected no error, got %v", err)
 	}
-	fsm.Event("reset")
-	if fsm.Current() != "one" {
-		t.Error("expected state to be 'one'")
+	if fsm.Current() != "start" {
+		t.Error("expected state to be 'start'")
 	}
-	fsm.Event("first")
-	fsm.Event("second")
-	if fsm.Current() != "three" {
-		t.Error("expected state to be 'three'")
+	if _, ok := fsm.Transition().(NotInTransitionError); !ok {
+		t.Error("expected no pending transition")
 	}
-	fsm.Event("reset")
-	if fsm.Current() != "one" {
-		t.Error("expected state to be 'one'")
+	if !fsm.Can("run") {
+		t.Error("expected to be able to run again")
 	}
 }
 
-func TestMultipleEvents(t *testing.T) {
+func TestCancelTransitionNotInProgress(t *testing.T) {
 	fsm := NewFSM(
 		"start",
 		Events{
-			{Name: "first", Src: []string{"start"}, Dst: "one"},
-			{Name: "second", Src: []string{"start"}, Dst: "two"},
-			{Name: "reset", Src: []string{"one"}, Dst: "reset_one"},
-			{Name: "reset", Src: []string{"two"}, Dst: "reset_two"},
-			{Name: "reset", Src: []string{"reset_one", "reset_two"}, Dst: "start"},
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
 		},
 		Callbacks{},
 	)
-
-	fsm.Event("first")
-	fsm.Event("reset")
-	if fsm.Current() != "reset_one" {
-		t.Error("expected state to be 'reset_one'")
-	}
-	fsm.Event("reset")
-	if fsm.Current() != "start" {
-		t.Error("expected state to be 'start'")
+	if _, ok := fsm.CancelTransition().(NotInTransitionError); !ok {
+		t.Error("expected 'NotInTransitionError'")
 	}
+}
 
-	fsm.Event("second")
-	fsm.Event("reset")
-	if fsm.Current() != "reset_two" {
-		t.Error("expected state to be 'reset_two'")
-	}
-	fsm.Event("reset")
-	if fsm.Current() != "start" {
-		t.Error("expected state to be 'start'")
+func TestCallbackNoError(t *testing.T) {
+	fsm := NewFSM(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+		},
+		Callbacks{
+			"run": func(e *Event) {
+			},
+		},
+	)
+	e := fsm.Event("run")
+	if e != nil {
+		t.Error("expected no error")
 	}
 }
 
-func TestGenericCallbacks(t *testing.T) {
-	beforeEvent := false
-	leaveState := false
-	enterState := false
-	afterEvent := false
-
+func TestCallbackError(t *testing.T) {
 	fsm := NewFSM(
 		"start",
 		Events{
 			{Name: "run", Src: []string{"start"}, Dst: "end"},
 		},
 		Callbacks{
-			"before_event": func(e *Event) {
-				beforeEvent = true
-			},
-			"leave_state": func(e *Event) {
-				leaveState = true
-			},
-			"enter_state": func(e *Event) {
-				enterState = true
-			},
-			"after_event": func(e *Event) {
-				afterEvent = true
+			"run": func(e *Event) {
+				e.Err = fmt.Errorf("error")
 			},
 		},
 	)
-
-	fsm.Event("run")
-	if !(beforeEvent && leaveState && enterState && afterEvent) {
-		t.Error("expected all callbacks to be called")
+	e := fsm.Event("run")
+	if e.Error() != "error" {
+		t.Error("expected error to be 'error'")
 	}
 }
 
-func TestSpecificCallbacks(t *testing.T) {
-	beforeEvent := false
-	leaveState := false
-	enterState := false
+func TestRollbackOnError(t *testing.T) {
 	afterEvent := false
-
 	fsm := NewFSM(
 		"start",
 		Events{
 			{Name: "run", Src: []string{"start"}, Dst: "end"},
 		},
 		Callbacks{
-			"before_run": func(e *Event) {
-				beforeEvent = true
-			},
-			"leave_start": func(e *Event) {
-				leaveState = true
-			},
 			"enter_end": func(e *Event) {
-				enterState = true
+				e.Err = fmt.Errorf("error")
 			},
-			"after_run": func(e *Event) {
+			"after_event": func(e *Event) {
 				afterEvent = true
 			},
 		},
 	)
+	fsm.SetRollbackOnError(true)
+	err := fsm.Event("run")
+	if e, ok := err.(RollbackError); !ok || e.Err.Error() != "error" {
+		t.Errorf("expected 'RollbackError' with the callback error, got %v", err)
+	}
+	if fsm.Current() != "start" {
+		t.Error("expected state to be rolled back to 'start'")
+	}
+	if afterEvent {
+		t.Error("expected no callbacks after the error")
+	}
+}
 
-	fsm.Event("run")
-	if !(beforeEvent && leaveState && enterState && afterEvent) {
-		t.Error("expected all callbacks to be called")
+// sliceError is an error type that can not be compared with ==.
+type sliceError []string
+
+func (e sliceError) Error() string {
+	return fmt.Sprint([]string(e))
+}
+
+func TestRollbackOnErrorUncomparable(t *testing.T) {
+	for _, fail := range []bool{false, true} {
+		fsm := NewFSM(
+			"start",
+			Events{
+				{Name: "run", Src: []string{"start"}, Dst: "end"},
+			},
+			Callbacks{
+				"before_run": func(e *Event) {
+					e.Err = sliceError{"before"}
+				},
+				"enter_end": func(e *Event) {
+					if fail {
+						e.Err = sliceError{"enter"}
+					}
+				},
+			},
+		)
+		fsm.SetRollbackOnError(true)
+		err := fsm.Event("run")
+		if fail {
+			if e, ok := err.(RollbackError); !ok || e.Err.Error() != "[enter]" {
+				t.Errorf("expected 'RollbackError' with the enter error, got %v", err)
+			}
+			if fsm.Current() != "start" {
+				t.Error("expected state to be rolled back to 'start'")
+			}
+		} else {
+			if err == nil || err.Error() != "[before]" {
+				t.Errorf("expected the before error, got %v", err)
+			}
+			if fsm.Current() != "end" {
+				t.Error("expected state to be 'end'")
+			}
+		}
 	}
 }
 
-func TestSpecificCallbacksShortform(t *testing.T) {
-	enterState := false
+func TestRollbackOnErrorDisabled(t *testing.T) {
 	afterEvent := false
-
 	fsm := NewFSM(
 		"start",
 		Events{
 			{Name: "run", Src: []string{"start"}, Dst: "end"},
 		},
 		Callbacks{
-			"end": func(e *Event) {
-				enterState = true
+			"enter_end": func(e *Event) {
+				e.Err = fmt.Errorf("error")
 			},
-			"run": func(e *Event) {
+			"after_event": func(e *Event) {
 				afterEvent = true
 			},
 		},
 	)
-
-	fsm.Event("run")
-	if !(enterState && afterEvent) {
+	err := fsm.Event("run")
+	if err == nil || err.Error() != "error" {
+		t.Errorf("expected the callback error, got %v", err)
+	}
+	if fsm.Current() != "end" {
+		t.Error("expected state to be 'end'")
+	}
+	if !afterEvent {
 		t.Error("expected all callbacks to be called")
 	}
 }
 
-func TestBeforeEventWithoutTransition(t *testing.T) {
-	beforeEvent := true
-
+func TestObserver(t *testing.T) {
+	var observations []string
 	fsm := NewFSM(
-		"start",
+		"closed",
 		Events{
-			{Name: "dontrun", Src: []string{"start"}, Dst: "start"},
+			{Name: "open", Src: []string{"closed"}, Dst: "open"},
+			{Name: "close", Src: []string{"open"}, Dst: "closed"},
+			{Name: "stay", Src: []string{"open"}, Dst: "open"},
 		},
 		Callbacks{
-			"before_event": func(e *Event) {
-				beforeEvent = true
+			"before_close": func(e *Event) {
+				if len(e.Args) > 0 {
+					e.Cancel()
+				}
 			},
 		},
 	)
+	fsm.SetObserver(func(from, to, event string, d time.Duration) {
+		if d < 0 {
+			t.Error("expected a positive duration")
+		}
+		observations = append(observations, from+"-"+event+"->"+to)
+	})
+	fsm.Event("open")
+	fsm.Event("stay")
+	fsm.Event("close", "cancel")
+	fsm.Event("close")
 
-	err := fsm.Event("dontrun")
-	if e, ok := err.(NoTransitionError); !ok && e.Err != nil {
-		t.Error("expected 'NoTransitionError' without custom error")
+	expected := []string{"closed-open->open", "open-close->closed"}
+	if fmt.Sprint(observations) != fmt.Sprint(expected) {
+		t.Errorf("expected %v, got %v", expected, observations)
 	}
+}
 
-	if fsm.Current() != "start" {
-		t.Error("expected state to be 'start'")
-	}
-	if !beforeEvent {
-		t.Error("expected callback to be called")
+func TestErrorObserver(t *testing.T) {
+	for _, observeNoTransition := range []bool{false, true} {
+		var observations []string
+		fsm := NewFSM(
+			"closed",
+			Events{
+				{Name: "open", Src: []string{"closed"}, Dst: "open"},
+				{Name: "close", Src: []string{"open"}, Dst: "closed"},
+				{Name: "stay", Src: []string{"closed"}, Dst: "closed"},
+				{Name: "lock", Src: []string{"closed"}, Dst: "locked", Guard: func(e *Event) bool {
+					return false
+				}},
+			},
+			Callbacks{
+				"before_open": func(e *Event) {
+					if len(e.Args) > 0 {
+						e.Cancel()
+					}
+				},
+				"enter_open": func(e *Event) {
+					e.Err = fmt.Errorf("callback error")
+				},
+			},
+		)
+		fsm.SetErrorObserver(func(event, src string, err error) {
+			observations = append(observations, fmt.Sprintf("%s@%s: %T", event, src, err))
+		}, observeNoTransition)
+
+		fsm.Event("kick")
+		fsm.Event("close")
+		fsm.Event("lock")
+		fsm.Event("stay")
+		fsm.Event("open", "cancel")
+		fsm.Event("open")
+
+		expected := []string{
+			"kick@closed: fsm.UnknownEventError",
+			"close@closed: fsm.InvalidEventError",
+			"lock@closed: fsm.GuardFailedError",
+		}
+		if observeNoTransition {
+			expected = append(expected, "stay@closed: fsm.NoTransitionError")
+		}
+		expected = append(expected,
+			"open@closed: fsm.CanceledError",
+			"open@closed: *errors.errorString",
+		)
+		if fmt.Sprint(observations) != fmt.Sprint(expected) {
+			t.Errorf("expected %v, got %v", expected, observations)
+		}
 	}
 }
 
-func TestCancelBeforeGenericEvent(t *testing.T) {
+func TestOnReject(t *testing.T) {
+	var rejections []string
 	fsm := NewFSM(
-		"start",
+		"closed",
 		Events{
-			{Name: "run", Src: []string{"start"}, Dst: "end"},
+			{Name: "open", Src: []string{"closed"}, Dst: "open"},
+			{Name: "close", Src: []string{"open"}, Dst: "closed"},
+			{Name: "lock", Src: []string{"closed"}, Dst: "locked", Guard: func(e *Event) bool {
+				return false
+			}},
 		},
 		Callbacks{
-			"before_event": func(e *Event) {
-				e.Cancel()
+			"before_open": func(e *Event) {
+				if len(e.Args) > 0 {
+					e.Cancel()
+				}
+			},
+			"enter_open": func(e *Event) {
+				e.Err = fmt.Errorf("callback error")
 			},
 		},
 	)
-	fsm.Event("run")
-	if fsm.Current() != "start" {
-		t.Error("expected state to be 'start'")
+	fsm.OnReject(func(event, state string, reason error) {
+		rejections = append(rejections, fmt.Sprintf("%s@%s: %T", event, state, reason))
+	})
+
+	fsm.Event("kick")
+	fsm.Event("close")
+	fsm.Event("lock")
+	fsm.Event("open", "cancel")
+	fsm.Event("open")
+
+	expected := []string{
+		"kick@closed: fsm.UnknownEventError",
+		"close@closed: fsm.InvalidEventError",
+	}
+	if fmt.Sprint(rejections) != fmt.Sprint(expected) {
+		t.Errorf("expected %v, got %v", expected, rejections)
 	}
 }
 
-func TestCancelBeforeSpecificEvent(t *testing.T) {
+func TestRecoverPanics(t *testing.T) {
+	tests := []struct {
+		callback string
+		state    string
+	}{
+		{"before_event", "start"},
+		{"leave_state", "start"},
+		{"enter_state", "end"},
+		{"after_event", "end"},
+	}
+	for _, test := range tests {
+		fsm := NewFSM(
+			"start",
+			Events{
+				{Name: "run", Src: []string{"start"}, Dst: "end"},
+			},
+			Callbacks{
+				test.callback: func(e *Event) {
+					panic(test.callback)
+				},
+			},
+		)
+		fsm.SetRecoverPanics(true)
+		err := fsm.Event("run")
+		if e, ok := err.(CallbackPanicError); !ok || e.Value != test.callback {
+			t.Errorf("expected 'CallbackPanicError' from %s, got %v", test.callback, err)
+		}
+		if fsm.Current() != test.state {
+			t.Errorf("expected state to be '%s' after panic in %s", test.state, test.callback)
+		}
+		if fsm.InTransition() {
+			t.Errorf("expected no pending transition after panic in %s", test.callback)
+		}
+		// The FSM must still be usable, with no mutex left locked.
+		fsm.SetState("start")
+		if _, ok := fsm.Event("run").(CallbackPanicError); !ok {
+			t.Errorf("expected the FSM to be usable after panic in %s", test.callback)
+		}
+	}
+}
+
+func TestRecoverPanicsAsync(t *testing.T) {
 	fsm := NewFSM(
 		"start",
 		Events{
 			{Name: "run", Src: []string{"start"}, Dst: "end"},
 		},
 		Callbacks{
-			"before_run": func(e *Event) {
-				e.Cancel()
+			"leave_start": func(e *Event) {
+				e.Async()
+			},
+			"enter_end": func(e *Event) {
+				panic("enter_end")
 			},
 		},
 	)
+	fsm.SetRecoverPanics(true)
 	fsm.Event("run")
-	if fsm.Current() != "start" {
-		t.Error("expected state to be 'start'")
+	if _, ok := fsm.Transition().(CallbackPanicError); !ok {
+		t.Error("expected 'CallbackPanicError' from Transition")
+	}
+	if fsm.InTransition() {
+		t.Error("expected no pending transition")
 	}
 }
 
-func TestCancelLeaveGenericState(t *testing.T) {
+func TestPanicsNotRecovered(t *testing.T) {
 	fsm := NewFSM(
 		"start",
 		Events{
 			{Name: "run", Src: []string{"start"}, Dst: "end"},
 		},
 		Callbacks{
-			"leave_state": func(e *Event) {
-				e.Cancel()
+			"before_event": func(e *Event) {
+				panic("before_event")
 			},
 		},
 	)
+	defer func() {
+		if recover() != "before_event" {
+			t.Error("expected the panic to propagate")
+		}
+	}()
 	fsm.Event("run")
-	if fsm.Current() != "start" {
-		t.Error("expected state to be 'start'")
-	}
 }
 
-func TestCancelLeaveSpecificState(t *testing.T) {
+func TestLogger(t *testing.T) {
 	fsm := NewFSM(
-		"start",
+		"closed",
 		Events{
-			{Name: "run", Src: []string{"start"}, Dst: "end"},
+			{Name: "open", Src: []string{"closed"}, Dst: "open"},
+			{Name: "close", Src: []string{"open"}, Dst: "closed"},
 		},
 		Callbacks{
-			"leave_start": func(e *Event) {
+			"leave_open": func(e *Event) {
 				e.Cancel()
 			},
 		},
 	)
-	fsm.Event("run")
-	if fsm.Current() != "start" {
-		t.Error("expected state to be 'start'")
+	logger := &captureLogger{}
+	fsm.SetLogger(logger)
+	fsm.Event("open")
+	fsm.Event("close")
+
+	expected := []string{
+		"fsm: before event open from closed to open",
+		"fsm: leave event open from closed to open",
+		"fsm: enter event open from closed to open",
+		"fsm: after event open from closed to open",
+		"fsm: before event close from open to closed",
+		"fsm: leave event close from open to closed",
+		"fsm: canceled event close from open to closed",
+	}
+	if fmt.Sprint(logger.lines) != fmt.Sprint(expected) {
+		t.Errorf("expected %q, got %q", expected, logger.lines)
 	}
 }
 
-func TestCancelWithError(t *testing.T) {
+func TestCallbackArgs(t *testing.T) {
 	fsm := NewFSM(
 		"start",
 		Events{
 			{Name: "run", Src: []string{"start"}, Dst: "end"},
 		},
 		Callbacks{
-			"before_event": func(e *Event) {
-				e.Cancel(fmt.Errorf("error"))
+			"run": func(e *Event) {
+				if len(e.Args) != 1 {
+					t.Error("too few arguments")
+				}
+				arg, ok := e.Args[0].(string)
+				if !ok {
+					t.Error("not a string argument")
+				}
+				if arg != "test" {
+					t.Error("incorrect argument")
+				}
 			},
 		},
 	)
-	err := fsm.Event("run")
-	if _, ok := err.(CanceledError); !ok {
-		t.Error("expected only 'CanceledError'")
+	fsm.Event("run", "test")
+}
+
+func TestEventArgHelpers(t *testing.T) {
+	e := &Event{Args: []interface{}{"test", 42}}
+
+	if arg, ok := e.Arg(1); !ok || arg != 42 {
+		t.Errorf("expected argument 42, got %v", arg)
+	}
+	if s, ok := e.StringArg(0); !ok || s != "test" {
+		t.Errorf("expected string argument 'test', got %q", s)
+	}
+	if n, ok := e.IntArg(1); !ok || n != 42 {
+		t.Errorf("expected int argument 42, got %d", n)
 	}
 
-	if e, ok := err.(CanceledError); ok && e.Err.Error() != "error" {
-		t.Error("expected 'CanceledError' with correct custom error")
+	for _, i := range []int{-1, 2} {
+		if _, ok := e.Arg(i); ok {
+			t.Errorf("expected no argument at index %d", i)
+		}
+		if _, ok := e.StringArg(i); ok {
+			t.Errorf("expected no string argument at index %d", i)
+		}
+		if _, ok := e.IntArg(i); ok {
+			t.Errorf("expected no int argument at index %d", i)
+		}
 	}
 
-	if fsm.Current() != "start" {
-		t.Error("expected state to be 'start'")
+	if _, ok := e.StringArg(1); ok {
+		t.Error("expected the int argument not to be a string")
+	}
+	if _, ok := e.IntArg(0); ok {
+		t.Error("expected the string argument not to be an int")
 	}
 }
 
-func TestAsyncTransitionGenericState(t *testing.T) {
+func TestEventWithPayload(t *testing.T) {
+	type order struct {
+		ID    int
+		Items []string
+	}
+	var got order
+	var ok bool
 	fsm := NewFSM(
 		"start",
 		Events{
-			{Name: "run", Src: []string{"start"}, Dst: "end"},
+			{Name: "submit", Src: []string{"start"}, Dst: "end"},
 		},
 		Callbacks{
-			"leave_state": func(e *Event) {
-				e.Async()
+			"after_event": func(e *Event) {
+				got, ok = e.Payload().(order)
+				if len(e.Args) != 0 {
+					t.Errorf("expected no arguments, got %v", e.Args)
+				}
 			},
 		},
 	)
-	fsm.Event("run")
-	if fsm.Current() != "start" {
-		t.Error("expected state to be 'start'")
+
+	if err := fsm.EventWithPayload("submit", order{7, []string{"book"}}); err != nil {
+		t.Fatal(err)
 	}
-	fsm.Transition()
-	if fsm.Current() != "end" {
-		t.Error("expected state to be 'end'")
+	if !ok || got.ID != 7 || fmt.Sprint(got.Items) != "[book]" {
+		t.Errorf("expected the order payload, got %v", got)
+	}
+	if p := (&Event{}).Payload(); p != nil {
+		t.Errorf("expected no payload, got %v", p)
 	}
 }
 
-func TestAsyncTransitionSpecificState(t *testing.T) {
+func TestEventWithPayloadTransitionWithContext(t *testing.T) {
+	var got interface{}
 	fsm := NewFSM(
 		"start",
 		Events{
-			{Name: "run", Src: []string{"start"}, Dst: "end"},
+			{Name: "submit", Src: []string{"start"}, Dst: "end"},
 		},
 		Callbacks{
 			"leave_start": func(e *Event) {
 				e.Async()
 			},
+			"after_event": func(e *Event) {
+				got = e.Payload()
+			},
 		},
 	)
-	fsm.Event("run")
-	if fsm.Current() != "start" {
-		t.Error("expected state to be 'start'")
+
+	if err := fsm.EventWithPayload("submit", 7); err == nil {
+		t.Fatal("expected an AsyncError")
 	}
-	fsm.Transition()
-	if fsm.Current() != "end" {
-		t.Error("expected state to be 'end'")
+	if err := fsm.TransitionWithContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got != 7 {
+		t.Errorf("expected the payload after TransitionWithContext, got %v", got)
 	}
 }
 
-func TestAsyncTransitionInProgress(t *testing.T) {
+func TestCallbackArgsAllPhases(t *testing.T) {
+	for _, async := range []bool{false, true} {
+		var phases []string
+		checkArgs := func(phase string) Callback {
+			return func(e *Event) {
+				if len(e.Args) == 1 && e.Args[0] == "test" {
+					phases = append(phases, phase)
+				}
+				if phase == "leave_state" && async {
+					e.Async()
+				}
+			}
+		}
+		fsm := NewFSM(
+			"start",
+			Events{
+				{Name: "run", Src: []string{"start"}, Dst: "end"},
+			},
+			Callbacks{
+				"before_event": checkArgs("before_event"),
+				"leave_state":  checkArgs("leave_state"),
+				"enter_state":  checkArgs("enter_state"),
+				"after_event":  checkArgs("after_event"),
+			},
+		)
+		fsm.Event("run", "test")
+		if async {
+			fsm.Transition()
+		}
+		expected := []string{"before_event", "leave_state", "enter_state", "after_event"}
+		if fmt.Sprint(phases) != fmt.Sprint(expected) {
+			t.Errorf("expected arguments in %v with async %v, got %v", expected, async, phases)
+		}
+	}
+}
+
+func TestCancelWith(t *testing.T) {
+	errDenied := fmt.Errorf("denied")
+	for _, callback := range []string{"before_run", "leave_start"} {
+		fsm := NewFSM(
+			"start",
+			Events{
+				{Name: "run", Src: []string{"start"}, Dst: "end"},
+			},
+			Callbacks{
+				callback: func(e *Event) {
+					e.CancelWith(errDenied)
+				},
+			},
+		)
+		if err := fsm.Event("run"); err != errDenied {
+			t.Errorf("expected the error from CancelWith in %s, got %v", callback, err)
+		}
+		if fsm.Current() != "start" {
+			t.Errorf("expected state to be 'start' after canceling in %s", callback)
+		}
+	}
+}
+
+func TestEventData(t *testing.T) {
+	var value interface{}
 	fsm := NewFSM(
 		"start",
 		Events{
 			{Name: "run", Src: []string{"start"}, Dst: "end"},
-			{Name: "reset", Src: []string{"end"}, Dst: "start"},
 		},
 		Callbacks{
+			"before_run": func(e *Event) {
+				if _, ok := e.Get("key"); ok {
+					t.Error("expected no value before Set")
+				}
+				e.Set("key", "value")
+			},
 			"leave_start": func(e *Event) {
 				e.Async()
 			},
+			"enter_end": func(e *Event) {
+				value, _ = e.Get("key")
+			},
 		},
 	)
 	fsm.Event("run")
-	err := fsm.Event("reset")
-	if e, ok := err.(InTransitionError); !ok && e.Event != "reset" {
-		t.Error("expected 'InTransitionError' with correct state")
-	}
 	fsm.Transition()
-	fsm.Event("reset")
-	if fsm.Current() != "start" {
-		t.Error("expected state to be 'start'")
+	if value != "value" {
+		t.Errorf("expected the value to be available in enter_end, got %v", value)
 	}
 }
 
-func TestAsyncTransitionNotInProgress(t *testing.T) {
+func TestEventMeta(t *testing.T) {
+	var meta map[string]interface{}
+	definition := map[string]interface{}{"permission": "admin"}
 	fsm := NewFSM(
 		"start",
 		Events{
-			{Name: "run", Src: []string{"start"}, Dst: "end"},
+			{Name: "run", Src: []string{"start"}, Dst: "end", Meta: definition},
 			{Name: "reset", Src: []string{"end"}, Dst: "start"},
 		},
-		Callbacks{},
-	)
-	err := fsm.Transition()
-	if _, ok := err.(NotInTransitionError); !ok {
-		t.Error("expected 'NotInTransitionError'")
-	}
-}
-
-func TestCallbackNoError(t *testing.T) {
-	fsm := NewFSM(
-		"start",
-		Events{
-			{Name: "run", Src: []string{"start"}, Dst: "end"},
-		},
 		Callbacks{
-			"run": func(e *Event) {
+			"after_event": func(e *Event) {
+				meta = e.Meta()
 			},
 		},
 	)
-	e := fsm.Event("run")
-	if e != nil {
-		t.Error("expected no error")
+	definition["permission"] = "changed"
+
+	fsm.Event("run")
+	if meta["permission"] != "admin" {
+		t.Errorf("expected permission 'admin', got %v", meta["permission"])
+	}
+	meta["permission"] = "changed"
+
+	fsm.Event("reset")
+	if meta != nil {
+		t.Errorf("expected no metadata, got %v", meta)
+	}
+
+	fsm.Event("run")
+	if meta["permission"] != "admin" {
+		t.Errorf("expected the metadata to be a copy, got %v", meta["permission"])
 	}
 }
 
-func TestCallbackError(t *testing.T) {
-	fsm := NewFSM(
+func TestNoDeadLock(t *testing.T) {
+	var fsm *FSM
+	fsm = NewFSM(
 		"start",
 		Events{
 			{Name: "run", Src: []string{"start"}, Dst: "end"},
 		},
 		Callbacks{
 			"run": func(e *Event) {
-				e.Err = fmt.Errorf("error")
+				fsm.Current() // Should not result in a panic / deadlock
 			},
 		},
 	)
-	e := fsm.Event("run")
-	if e.Error() != "error" {
-		t.Error("expected error to be 'error'")
-	}
+	fsm.Event("run")
 }
 
-func TestCallbackArgs(t *testing.T) {
+func TestThreadSafetyRaceCondition(t *testing.T) {
 	fsm := NewFSM(
 		"start",
 		Events{
@@ -514,57 +3791,93 @@ func TestCallbackArgs(t *testing.T) {
 		},
 		Callbacks{
 			"run": func(e *Event) {
-				if len(e.Args) != 1 {
-					t.Error("too few arguments")
-				}
-				arg, ok := e.Args[0].(string)
-				if !ok {
-					t.Error("not a string argument")
-				}
-				if arg != "test" {
-					t.Error("incorrect argument")
-				}
 			},
 		},
 	)
-	fsm.Event("run", "test")
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_ = fsm.Current()
+	}()
+	fsm.Event("run")
+	wg.Wait()
 }
 
-func TestNoDeadLock(t *testing.T) {
-	var fsm *FSM
-	fsm = NewFSM(
+func TestThreadSafetyCurrent(t *testing.T) {
+	fsm := NewFSM(
 		"start",
 		Events{
 			{Name: "run", Src: []string{"start"}, Dst: "end"},
+			{Name: "reset", Src: []string{"end"}, Dst: "start"},
 		},
-		Callbacks{
-			"run": func(e *Event) {
-				fsm.Current() // Should not result in a panic / deadlock
-			},
-		},
+		Callbacks{},
 	)
-	fsm.Event("run")
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				if current := fsm.Current(); current != "start" && current != "end" {
+					t.Errorf("unexpected state %q", current)
+					return
+				}
+				fsm.Is("end")
+			}
+		}()
+	}
+	for i := 0; i < 1000; i++ {
+		fsm.Event("run")
+		fsm.Event("reset")
+	}
+	close(done)
+	wg.Wait()
 }
 
-func TestThreadSafetyRaceCondition(t *testing.T) {
+func TestThreadSafetyAsyncTransition(t *testing.T) {
 	fsm := NewFSM(
 		"start",
 		Events{
 			{Name: "run", Src: []string{"start"}, Dst: "end"},
 		},
 		Callbacks{
-			"run": func(e *Event) {
+			"leave_start": func(e *Event) {
+				e.Async()
 			},
 		},
 	)
+	started := make(chan struct{})
+	done := make(chan struct{})
 	var wg sync.WaitGroup
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		_ = fsm.Current()
+		_ = fsm.Can("run")
+		close(started)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				_ = fsm.Can("run")
+			}
+		}
 	}()
+	<-started
 	fsm.Event("run")
+	fsm.Transition()
+	close(done)
 	wg.Wait()
+	if fsm.Current() != "end" {
+		t.Error("expected state to be 'end'")
+	}
 }
 
 func TestDoubleTransition(t *testing.T) {
@@ -606,6 +3919,149 @@ func TestDoubleTransition(t *testing.T) {
 	wg.Wait()
 }
 
+func TestEventQueueAfterPanic(t *testing.T) {
+	panics := true
+	fsm := NewFSM(
+		"a",
+		Events{
+			{Name: "go", Src: []string{"a"}, Dst: "b"},
+		},
+		Callbacks{
+			"before_go": func(e *Event) {
+				if panics {
+					panic("boom")
+				}
+			},
+		},
+	)
+	fsm.SetEventQueue(true)
+
+	func() {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Error("expected the callback to panic")
+			}
+		}()
+		fsm.Event("go")
+	}()
+
+	panics = false
+	if err := fsm.Event("go"); err != nil {
+		t.Fatal(err)
+	}
+	if fsm.Current() != "b" {
+		t.Errorf("expected the event after the panic to be fired, got state %s", fsm.Current())
+	}
+}
+
+func TestEventQueue(t *testing.T) {
+	var fsm *FSM
+	fsm = NewFSM(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "running"},
+			{Name: "stop", Src: []string{"running"}, Dst: "end"},
+		},
+		Callbacks{
+			"after_run": func(e *Event) {
+				if err := fsm.Event("stop"); err != nil {
+					t.Errorf("expected queued event to return no error, got %v", err)
+				}
+				if fsm.Current() != "running" {
+					t.Error("expected queued event to wait for the transition")
+				}
+			},
+		},
+	)
+	fsm.SetEventQueue(true)
+	if err := fsm.Event("run"); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+	if fsm.Current() != "end" {
+		t.Error("expected state to be 'end'")
+	}
+}
+
+func TestMaxTransitionDepth(t *testing.T) {
+	var fsm *FSM
+	var transitions int
+	var depthErr error
+	fsm = NewFSM(
+		"off",
+		Events{
+			{Name: "toggle", Src: []string{"off"}, Dst: "on"},
+			{Name: "toggle", Src: []string{"on"}, Dst: "off"},
+		},
+		Callbacks{
+			"after_toggle": func(e *Event) {
+				transitions++
+				if err := fsm.Event("toggle"); err != nil {
+					depthErr = err
+				}
+			},
+		},
+	)
+	fsm.SetEventQueue(true)
+	fsm.SetMaxTransitionDepth(3)
+	if err := fsm.Event("toggle"); err != nil {
+		t.Fatal(err)
+	}
+	if e, ok := depthErr.(TransitionDepthExceededError); !ok || e.Event != "toggle" || e.Depth != 3 {
+		t.Errorf("expected 'TransitionDepthExceededError', got %v", depthErr)
+	}
+	if transitions != 4 {
+		t.Errorf("expected 4 transitions, got %d", transitions)
+	}
+	if fsm.Current() != "off" {
+		t.Errorf("expected state to be 'off', got %s", fsm.Current())
+	}
+
+	// A new event starts a new chain.
+	depthErr = nil
+	if err := fsm.Event("toggle"); err != nil {
+		t.Fatal(err)
+	}
+	if transitions != 8 || depthErr == nil {
+		t.Errorf("expected 8 transitions and a depth error, got %d and %v", transitions, depthErr)
+	}
+}
+
+func TestEventQueueAsync(t *testing.T) {
+	var order []string
+	fsm := NewFSM(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "running"},
+			{Name: "pause", Src: []string{"running"}, Dst: "paused"},
+			{Name: "stop", Src: []string{"paused"}, Dst: "end"},
+		},
+		Callbacks{
+			"leave_start": func(e *Event) {
+				e.Async()
+			},
+			"enter_state": func(e *Event) {
+				order = append(order, e.Dst)
+			},
+		},
+	)
+	fsm.SetEventQueue(true)
+	fsm.Event("run")
+	if err := fsm.Event("pause"); err != nil {
+		t.Errorf("expected queued event to return no error, got %v", err)
+	}
+	fsm.Event("stop")
+	if fsm.Current() != "start" {
+		t.Error("expected state to be 'start'")
+	}
+	if err := fsm.Transition(); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+	expected := []string{"running", "paused", "end"}
+	if fmt.Sprint(order) != fmt.Sprint(expected) {
+		t.Errorf("expected %v, got %v", expected, order)
+	}
+}
+
 func TestNoTransition(t *testing.T) {
 	fsm := NewFSM(
 		"start",
@@ -808,3 +4264,52 @@ func ExampleFSM_Transition() {
 	// closed
 	// open
 }
+
+func BenchmarkCurrent(b *testing.B) {
+	fsm := NewFSM(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+		},
+		Callbacks{},
+	)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			fsm.Current()
+		}
+	})
+}
+
+func BenchmarkCurrentLocked(b *testing.B) {
+	fsm := NewFSM(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+		},
+		Callbacks{},
+	)
+	// Reads the state the way Current did before it was lock-free.
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			fsm.stateMu.RLock()
+			_ = fsm.current
+			fsm.stateMu.RUnlock()
+		}
+	})
+}
+
+func BenchmarkAvailableTransitions(b *testing.B) {
+	var events Events
+	for i := 0; i < 500; i++ {
+		events = append(events, EventDesc{
+			Name: fmt.Sprintf("event%d", i),
+			Src:  []string{fmt.Sprintf("state%d", i%50)},
+			Dst:  fmt.Sprintf("state%d", (i+1)%50),
+		})
+	}
+	fsm := NewFSM("state0", events, Callbacks{})
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		fsm.AvailableTransitions()
+	}
+}
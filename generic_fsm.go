@@ -0,0 +1,232 @@
+// Copyright (c) 2013 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fsm
+
+import (
+	"context"
+	"fmt"
+)
+
+// TypedFSM is a state machine with events of type E and states of type S
+// instead of strings. It wraps a FSM that uses the names of the events and
+// states, as formatted by fmt.Sprint, which must be unique per type.
+//
+// It has to be created with NewTypedFSM to function properly.
+type TypedFSM[E, S comparable] struct {
+	fsm    *FSM
+	events map[string]E
+	states map[string]S
+
+	// normalize is the key normalizer of the FSM, the events and states are
+	// mapped by their normalized names.
+	normalize func(string) string
+}
+
+// TypedEventDesc represents an event when initializing a TypedFSM, like
+// EventDesc.
+type TypedEventDesc[E, S comparable] struct {
+	// Name is the event used when calling for a transition.
+	Name E
+
+	// Src is a slice of source states that the FSM must be in to perform a
+	// state transition.
+	Src []S
+
+	// Dst is the destination state that the FSM will be in if the transition
+	// succeds.
+	Dst S
+
+	// Guard is an optional condition that must return true for the transition
+	// to be performed, see EventDesc.Guard.
+	Guard func(*TypedEvent[E, S]) bool
+}
+
+// TypedEvent is the info that get passed as a reference in the callbacks of a
+// TypedFSM. The embedded Event gives access to the arguments and the error and
+// is used to cancel the transition or make it asynchronous.
+type TypedEvent[E, S comparable] struct {
+	*Event
+
+	// Name is the event.
+	Name E
+
+	// Src is the state before the transition.
+	Src S
+
+	// Dst is the state after the transition.
+	Dst S
+}
+
+// TypedCallback is a function type that callbacks of a TypedFSM should use.
+type TypedCallback[E, S comparable] func(*TypedEvent[E, S])
+
+// NewTypedFSM constructs a TypedFSM from events and an initial state, like
+// NewFSMWithError. Callbacks are set after construction with the On methods.
+//
+// It returns an AmbiguousNameError if two events or two states have the same
+// name, also after normalization by WithKeyNormalizer, or a state has the name
+// of AnyState.
+func NewTypedFSM[E, S comparable](initial S, events []TypedEventDesc[E, S], opts ...Option) (*TypedFSM[E, S], error) {
+	// The names are needed before the FSM is constructed, so the options are
+	// applied to a probe to find the key normalizer.
+	probe := &FSM{}
+	for _, opt := range opts {
+		opt(probe)
+	}
+	t := &TypedFSM[E, S]{
+		events:    make(map[string]E),
+		states:    make(map[string]S),
+		normalize: probe.normalize,
+	}
+
+	initialName, err := t.addState(initial)
+	if err != nil {
+		return nil, err
+	}
+
+	descs := make([]EventDesc, 0, len(events))
+	for _, e := range events {
+		var d EventDesc
+		if d.Name, err = t.addEvent(e.Name); err != nil {
+			return nil, err
+		}
+		for _, src := range e.Src {
+			name, err := t.addState(src)
+			if err != nil {
+				return nil, err
+			}
+			d.Src = append(d.Src, name)
+		}
+		if d.Dst, err = t.addState(e.Dst); err != nil {
+			return nil, err
+		}
+		if guard := e.Guard; guard != nil {
+			d.Guard = func(e *Event) bool {
+				return guard(t.typed(e))
+			}
+		}
+		descs = append(descs, d)
+	}
+
+	if t.fsm, err = NewFSMWithError(initialName, descs, Callbacks{}, opts...); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// FSM returns the underlying FSM, for the functionality that is not typed.
+func (t *TypedFSM[E, S]) FSM() *FSM {
+	return t.fsm
+}
+
+// Current returns the current state of the FSM.
+func (t *TypedFSM[E, S]) Current() S {
+	return t.states[t.fsm.Current()]
+}
+
+// Is returns true if state is the current state.
+func (t *TypedFSM[E, S]) Is(state S) bool {
+	return t.Current() == state
+}
+
+// Can returns true if event can occur in the current state.
+func (t *TypedFSM[E, S]) Can(event E) bool {
+	name, ok := t.eventName(event)
+	return ok && t.fsm.Can(name)
+}
+
+// Event initiates a state transition with the named event, see FSM.Event.
+func (t *TypedFSM[E, S]) Event(event E, args ...interface{}) error {
+	return t.EventWithContext(context.Background(), event, args...)
+}
+
+// EventWithContext is like Event but takes a context, see
+// FSM.EventWithContext.
+func (t *TypedFSM[E, S]) EventWithContext(ctx context.Context, event E, args ...interface{}) error {
+	name, ok := t.eventName(event)
+	if !ok {
+		return UnknownEventError{name}
+	}
+	return t.fsm.EventWithContext(ctx, name, args...)
+}
+
+// OnBeforeEvent sets the callback called before event, see FSM.OnBeforeEvent.
+func (t *TypedFSM[E, S]) OnBeforeEvent(event E, fn TypedCallback[E, S]) {
+	t.fsm.OnBeforeEvent(fmt.Sprint(event), t.callback(fn))
+}
+
+// OnLeave sets the callback called before leaving state, see FSM.OnLeave.
+func (t *TypedFSM[E, S]) OnLeave(state S, fn TypedCallback[E, S]) {
+	t.fsm.OnLeave(fmt.Sprint(state), t.callback(fn))
+}
+
+// OnEnter sets the callback called after entering state, see FSM.OnEnter.
+func (t *TypedFSM[E, S]) OnEnter(state S, fn TypedCallback[E, S]) {
+	t.fsm.OnEnter(fmt.Sprint(state), t.callback(fn))
+}
+
+// OnAfterEvent sets the callback called after event, see FSM.OnAfterEvent.
+func (t *TypedFSM[E, S]) OnAfterEvent(event E, fn TypedCallback[E, S]) {
+	t.fsm.OnAfterEvent(fmt.Sprint(event), t.callback(fn))
+}
+
+// addEvent registers an event and returns its name.
+func (t *TypedFSM[E, S]) addEvent(event E) (string, error) {
+	name := fmt.Sprint(event)
+	key := t.normalize(name)
+	if e, ok := t.events[key]; ok && e != event {
+		return "", AmbiguousNameError{name}
+	}
+	t.events[key] = event
+	return name, nil
+}
+
+// addState registers a state and returns its name.
+func (t *TypedFSM[E, S]) addState(state S) (string, error) {
+	name := fmt.Sprint(state)
+	key := t.normalize(name)
+	if s, ok := t.states[key]; (ok && s != state) || name == AnyState {
+		return "", AmbiguousNameError{name}
+	}
+	t.states[key] = state
+	return name, nil
+}
+
+// eventName returns the name of event and false if the name belongs to
+// another event.
+func (t *TypedFSM[E, S]) eventName(event E) (string, bool) {
+	name := fmt.Sprint(event)
+	if e, ok := t.events[t.normalize(name)]; ok && e != event {
+		return name, false
+	}
+	return name, true
+}
+
+// callback wraps a typed callback.
+func (t *TypedFSM[E, S]) callback(fn TypedCallback[E, S]) Callback {
+	return func(e *Event) {
+		fn(t.typed(e))
+	}
+}
+
+// typed returns the typed version of an event.
+func (t *TypedFSM[E, S]) typed(e *Event) *TypedEvent[E, S] {
+	return &TypedEvent[E, S]{
+		Event: e,
+		Name:  t.events[e.Event],
+		Src:   t.states[e.Src],
+		Dst:   t.states[e.Dst],
+	}
+}
@@ -0,0 +1,231 @@
+// Copyright (c) 2013 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fsm
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+type doorState string
+
+type doorEvent string
+
+const (
+	doorClosed doorState = "closed"
+	doorOpen   doorState = "open"
+
+	doorOpenEvent  doorEvent = "open"
+	doorCloseEvent doorEvent = "close"
+)
+
+type lightState int
+
+const (
+	lightOff lightState = iota
+	lightOn
+)
+
+func TestTypedFSMString(t *testing.T) {
+	var calls []string
+	fsm, err := NewTypedFSM(
+		doorClosed,
+		[]TypedEventDesc[doorEvent, doorState]{
+			{Name: doorOpenEvent, Src: []doorState{doorClosed}, Dst: doorOpen},
+			{Name: doorCloseEvent, Src: []doorState{doorOpen}, Dst: doorClosed},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsm.OnBeforeEvent(doorOpenEvent, func(e *TypedEvent[doorEvent, doorState]) {
+		calls = append(calls, fmt.Sprintf("before %s %s>%s", e.Name, e.Src, e.Dst))
+	})
+	fsm.OnEnter(doorOpen, func(e *TypedEvent[doorEvent, doorState]) {
+		calls = append(calls, fmt.Sprintf("enter %s", e.Dst))
+	})
+	fsm.OnLeave(doorOpen, func(e *TypedEvent[doorEvent, doorState]) {
+		e.Cancel()
+	})
+
+	if !fsm.Can(doorOpenEvent) || fsm.Can(doorCloseEvent) {
+		t.Error("expected only open to be possible")
+	}
+	if err := fsm.Event(doorOpenEvent); err != nil {
+		t.Fatal(err)
+	}
+	if fsm.Current() != doorOpen || !fsm.Is(doorOpen) {
+		t.Errorf("expected state to be 'open', got %v", fsm.Current())
+	}
+	if _, ok := fsm.Event(doorCloseEvent).(CanceledError); !ok {
+		t.Error("expected the canceled close to give a 'CanceledError'")
+	}
+	if fsm.Current() != doorOpen {
+		t.Error("expected state to still be 'open'")
+	}
+	if fmt.Sprint(calls) != "[before open closed>open enter open]" {
+		t.Errorf("unexpected callbacks %v", calls)
+	}
+	if _, ok := fsm.Event("unknown").(UnknownEventError); !ok {
+		t.Error("expected 'UnknownEventError'")
+	}
+}
+
+func TestTypedFSMInt(t *testing.T) {
+	var entered []lightState
+	fsm, err := NewTypedFSM(
+		lightOff,
+		[]TypedEventDesc[int, lightState]{
+			{Name: 1, Src: []lightState{lightOff}, Dst: lightOn, Guard: func(e *TypedEvent[int, lightState]) bool {
+				return len(e.Args) == 0
+			}},
+			{Name: 0, Src: []lightState{lightOn}, Dst: lightOff},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsm.OnAfterEvent(1, func(e *TypedEvent[int, lightState]) {
+		entered = append(entered, e.Dst)
+	})
+
+	if _, ok := fsm.Event(1, "blocked").(GuardFailedError); !ok {
+		t.Error("expected 'GuardFailedError'")
+	}
+	if err := fsm.Event(1); err != nil {
+		t.Fatal(err)
+	}
+	if fsm.Current() != lightOn {
+		t.Errorf("expected state to be on, got %v", fsm.Current())
+	}
+	if err := fsm.Event(0); err != nil {
+		t.Fatal(err)
+	}
+	if fsm.Current() != lightOff {
+		t.Errorf("expected state to be off, got %v", fsm.Current())
+	}
+	if fmt.Sprint(entered) != fmt.Sprint([]lightState{lightOn}) {
+		t.Errorf("unexpected after callbacks %v", entered)
+	}
+	if fsm.FSM().Current() != "0" {
+		t.Errorf("expected the state name to be '0', got %s", fsm.FSM().Current())
+	}
+}
+
+func TestTypedFSMIntStateIntEvent(t *testing.T) {
+	const (
+		stateIdle = iota
+		stateRunning
+	)
+	const (
+		eventStart = iota
+		eventStop
+	)
+	fsm, err := NewTypedFSM(
+		stateIdle,
+		[]TypedEventDesc[int, int]{
+			{Name: eventStart, Src: []int{stateIdle}, Dst: stateRunning},
+			{Name: eventStop, Src: []int{stateRunning}, Dst: stateIdle},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !fsm.Can(eventStart) || fsm.Can(eventStop) {
+		t.Error("expected only the start event to be possible")
+	}
+	if err := fsm.Event(eventStart); err != nil {
+		t.Fatal(err)
+	}
+	if !fsm.Is(stateRunning) {
+		t.Errorf("expected state to be running, got %d", fsm.Current())
+	}
+	if _, ok := fsm.Event(eventStart).(InvalidEventError); !ok {
+		t.Error("expected 'InvalidEventError'")
+	}
+	if err := fsm.Event(eventStop); err != nil {
+		t.Fatal(err)
+	}
+	if fsm.Current() != stateIdle {
+		t.Errorf("expected state to be idle, got %d", fsm.Current())
+	}
+}
+
+// sameName is a state type whose values all have the same name.
+type sameName int
+
+func (s sameName) String() string {
+	return "same"
+}
+
+func TestTypedFSMAmbiguousName(t *testing.T) {
+	_, err := NewTypedFSM(
+		sameName(1),
+		[]TypedEventDesc[string, sameName]{
+			{Name: "next", Src: []sameName{1}, Dst: 2},
+		},
+	)
+	if e, ok := err.(AmbiguousNameError); !ok || e.Name != "same" {
+		t.Errorf("expected 'AmbiguousNameError' for 'same', got %v", err)
+	}
+}
+
+func TestTypedFSMKeyNormalizer(t *testing.T) {
+	const (
+		closed doorState = "Closed"
+		opened doorState = "Opened"
+		open   doorEvent = "Open"
+	)
+	fsm, err := NewTypedFSM(
+		closed,
+		[]TypedEventDesc[doorEvent, doorState]{
+			{Name: open, Src: []doorState{closed}, Dst: opened},
+		},
+		WithKeyNormalizer(strings.ToLower),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fsm.Current() != closed {
+		t.Errorf("expected state to be %q, got %q", closed, fsm.Current())
+	}
+
+	var got *TypedEvent[doorEvent, doorState]
+	fsm.OnEnter(opened, func(e *TypedEvent[doorEvent, doorState]) {
+		got = e
+	})
+	if err := fsm.Event(open); err != nil {
+		t.Fatal(err)
+	}
+	if fsm.Current() != opened {
+		t.Errorf("expected state to be %q, got %q", opened, fsm.Current())
+	}
+	if got == nil || got.Name != open || got.Src != closed || got.Dst != opened {
+		t.Errorf("expected the typed event %q from %q to %q, got %+v", open, closed, opened, got)
+	}
+
+	_, err = NewTypedFSM(
+		closed,
+		[]TypedEventDesc[doorEvent, doorState]{
+			{Name: open, Src: []doorState{closed}, Dst: "closed"},
+		},
+		WithKeyNormalizer(strings.ToLower),
+	)
+	if e, ok := err.(AmbiguousNameError); !ok || e.Name != "closed" {
+		t.Errorf("expected 'AmbiguousNameError' for 'closed', got %v", err)
+	}
+}
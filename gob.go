@@ -0,0 +1,137 @@
+// Copyright (c) 2013 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fsm
+
+import (
+	"bytes"
+	"encoding/gob"
+	"sort"
+)
+
+// gobDefinition is the gob representation of a FSM.
+type gobDefinition struct {
+	Initial      string
+	Current      string
+	Transitions  []gobTransition
+	Aliases      map[string]string
+	Descriptions map[string]string
+}
+
+// gobTransition is the gob representation of a transition.
+type gobTransition struct {
+	Event   string
+	Src     string
+	Dst     string
+	ReEnter bool
+}
+
+// GobEncode encodes the definition of the FSM, with its initial state,
+// transitions, aliases and descriptions, and the current state with gob. The
+// callbacks are not encoded and have to be set again on the decoded FSM with
+// OnBeforeEvent, OnLeave, OnEnter and OnAfterEvent.
+//
+// Guards, Choose functions, actions and metadata can not be encoded either, so
+// GobEncode returns UnencodableTransitionError for a transition with any of
+// them instead of encoding a different definition. A pending asynchronous
+// transition is not encoded, like with MarshalJSON.
+func (f *FSM) GobEncode() ([]byte, error) {
+	f.stateMu.RLock()
+	defer f.stateMu.RUnlock()
+	d := gobDefinition{
+		Initial:      f.initial,
+		Current:      f.current,
+		Aliases:      f.aliases,
+		Descriptions: f.descriptions,
+	}
+
+	for k, dsts := range f.transitions {
+		for _, v := range dsts {
+			if v.guard != nil || v.choose != nil || v.action != nil || len(v.meta) > 0 {
+				return nil, UnencodableTransitionError{k.event, k.src}
+			}
+			d.Transitions = append(d.Transitions, gobTransition{k.event, k.src, v.dst, v.reEnter})
+		}
+	}
+	sort.Slice(d.Transitions, func(i, j int) bool {
+		a, b := d.Transitions[i], d.Transitions[j]
+		if a.Src != b.Src {
+			return a.Src < b.Src
+		}
+		if a.Event != b.Event {
+			return a.Event < b.Event
+		}
+		return a.Dst < b.Dst
+	})
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(d); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// GobDecode replaces the definition and current state of the FSM with the ones
+// encoded by GobEncode, typically into a new zero FSM. All callbacks, final
+// states, disabled events, state timeouts and any pending asynchronous
+// transition are removed and no callbacks are called.
+//
+// Like NewFSMWithError, it returns EmptyStateError or EmptyEventError for
+// empty names and, like RestoreState, UnknownStateError if the current state is
+// not defined, leaving the FSM unchanged.
+func (f *FSM) GobDecode(data []byte) error {
+	var d gobDefinition
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&d); err != nil {
+		return err
+	}
+
+	events := make(Events, 0, len(d.Transitions))
+	for _, t := range d.Transitions {
+		events = append(events, EventDesc{Name: t.Event, Src: []string{t.Src}, Dst: t.Dst, ReEnter: t.ReEnter})
+	}
+	if err := checkNames(d.Initial, events); err != nil {
+		return err
+	}
+	n, err := newFSM(d.Initial, events, nil, nil)
+	if err != nil {
+		return err
+	}
+	if d.Current != n.initial && !n.states[d.Current] {
+		return UnknownStateError{d.Current}
+	}
+
+	f.eventMu.Lock()
+	defer f.eventMu.Unlock()
+	f.stateMu.Lock()
+	defer f.stateMu.Unlock()
+
+	if f.transitionerObj == nil {
+		f.transitionerObj = n.transitionerObj
+	}
+	f.initial = n.initial
+	f.transitions = n.transitions
+	f.states = n.states
+	f.available = n.available
+	f.aliases = d.Aliases
+	f.descriptions = d.Descriptions
+	f.callbacks = n.callbacks
+	f.hooks = nil
+	f.final = nil
+	f.disabled = nil
+	f.timeouts = nil
+	f.transition = nil
+	f.pending = nil
+	f.setCurrent(d.Current)
+	return nil
+}
@@ -0,0 +1,170 @@
+// Copyright (c) 2013 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fsm
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestGobRoundTrip(t *testing.T) {
+	fsm := NewFSM(
+		"closed",
+		Events{
+			{Name: "open", Src: []string{"closed"}, Dst: "open", Aliases: []string{"unlatch"}, Description: "open the door"},
+			{Name: "close", Src: []string{"open"}, Dst: "closed"},
+			{Name: "kick", Src: []string{"closed", "open"}, Dst: "broken"},
+		},
+		Callbacks{},
+	)
+	fsm.Event("open")
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(fsm); err != nil {
+		t.Fatal(err)
+	}
+	var decoded FSM
+	if err := gob.NewDecoder(&buf).Decode(&decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded.Current() != "open" {
+		t.Errorf("expected state to be 'open', got %s", decoded.Current())
+	}
+	if fmt.Sprint(decoded.TransitionTable()) != fmt.Sprint(fsm.TransitionTable()) {
+		t.Errorf("expected transitions %v, got %v", fsm.TransitionTable(), decoded.TransitionTable())
+	}
+	if decoded.Description("unlatch") != "open the door" {
+		t.Errorf("expected the description of the alias, got %q", decoded.Description("unlatch"))
+	}
+
+	var entered []string
+	decoded.OnEnter("closed", func(e *Event) {
+		entered = append(entered, e.Dst)
+	})
+	if err := decoded.Event("close"); err != nil {
+		t.Fatal(err)
+	}
+	if err := decoded.Event("unlatch"); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Current() != "open" || fmt.Sprint(entered) != "[closed]" {
+		t.Errorf("expected the decoded FSM to work with new callbacks, got %s and %v", decoded.Current(), entered)
+	}
+
+	decoded.Reset(false)
+	if decoded.Current() != "closed" {
+		t.Errorf("expected the initial state to be 'closed', got %s", decoded.Current())
+	}
+}
+
+func TestGobUnencodableTransitions(t *testing.T) {
+	for name, desc := range map[string]EventDesc{
+		"guard": {Name: "open", Src: []string{"closed"}, Dst: "open", Guard: func(e *Event) bool {
+			return true
+		}},
+		"action": {Name: "open", Src: []string{"closed"}, Action: func(e *Event) {}},
+		"choose": {Name: "open", Src: []string{"closed"}, Choose: func(e *Event) string {
+			return "closed"
+		}},
+		"meta": {Name: "open", Src: []string{"closed"}, Dst: "open", Meta: map[string]interface{}{"cost": 1}},
+	} {
+		fsm := NewFSM(
+			"closed",
+			Events{
+				{Name: "close", Src: []string{"open"}, Dst: "closed"},
+				desc,
+			},
+			Callbacks{},
+		)
+
+		_, err := fsm.GobEncode()
+		if err != (UnencodableTransitionError{"open", "closed"}) {
+			t.Errorf("%s: expected UnencodableTransitionError, got %v", name, err)
+		}
+	}
+}
+
+func TestGobDecodeInvalid(t *testing.T) {
+	for name, test := range map[string]struct {
+		d   gobDefinition
+		err error
+	}{
+		"unknown current": {
+			gobDefinition{Initial: "closed", Current: "nonexistent", Transitions: []gobTransition{{"open", "closed", "open", false}}},
+			UnknownStateError{"nonexistent"},
+		},
+		"empty initial": {
+			gobDefinition{Transitions: []gobTransition{{"open", "closed", "open", false}}},
+			EmptyStateError{},
+		},
+		"empty event": {
+			gobDefinition{Initial: "closed", Current: "closed", Transitions: []gobTransition{{"", "closed", "open", false}}},
+			EmptyEventError{},
+		},
+	} {
+		var buf bytes.Buffer
+		if err := gob.NewEncoder(&buf).Encode(test.d); err != nil {
+			t.Fatal(err)
+		}
+		fsm := NewFSM("start", Events{{Name: "go", Src: []string{"start"}, Dst: "end"}}, Callbacks{})
+		if err := fsm.GobDecode(buf.Bytes()); err != test.err {
+			t.Errorf("%s: expected %v, got %v", name, test.err, err)
+		}
+		if fsm.Current() != "start" {
+			t.Errorf("%s: expected the FSM to be unchanged, got state %s", name, fsm.Current())
+		}
+	}
+}
+
+func TestGobDecodeResetsSettings(t *testing.T) {
+	src := NewFSM(
+		"closed",
+		Events{
+			{Name: "open", Src: []string{"closed"}, Dst: "open"},
+		},
+		Callbacks{},
+	)
+	data, err := src.GobEncode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fsm := NewFSM(
+		"closed",
+		Events{
+			{Name: "open", Src: []string{"closed"}, Dst: "open"},
+		},
+		Callbacks{},
+	)
+	fsm.SetFinalStates("closed")
+	fsm.DisableEvent("open")
+	fsm.SetStateTimeout("open", time.Hour, "close")
+	if err := fsm.GobDecode(data); err != nil {
+		t.Fatal(err)
+	}
+	if fsm.IsFinal() {
+		t.Error("expected the final states to be reset")
+	}
+	if err := fsm.Event("open"); err != nil {
+		t.Fatalf("expected the disabled events to be reset, got %v", err)
+	}
+	if fsm.timer != nil {
+		t.Error("expected the state timeouts to be reset")
+	}
+}
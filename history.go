@@ -0,0 +1,111 @@
+// Copyright (c) 2013 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fsm
+
+import "time"
+
+// TransitionRecord is a completed transition recorded in the history.
+type TransitionRecord struct {
+	// Event is the event name.
+	Event string
+
+	// Src is the state before the transition.
+	Src string
+
+	// Dst is the state after the transition.
+	Dst string
+
+	// Time is when the transition completed.
+	Time time.Time
+}
+
+// SetHistorySize sets the number of completed transitions kept in the history.
+// The oldest records are discarded when the history is full. A size of 0, the
+// default, disables the history.
+func (f *FSM) SetHistorySize(n int) {
+	f.stateMu.Lock()
+	defer f.stateMu.Unlock()
+
+	if n <= 0 {
+		f.history = nil
+		f.historyNext = 0
+		f.historySize = 0
+		return
+	}
+
+	records := f.historyRecords()
+	if len(records) > n {
+		records = records[len(records)-n:]
+	}
+	f.history = make([]TransitionRecord, len(records), n)
+	copy(f.history, records)
+	f.historyNext = len(records) % n
+	f.historySize = n
+}
+
+// History returns the recorded transitions, oldest first.
+func (f *FSM) History() []TransitionRecord {
+	f.stateMu.RLock()
+	defer f.stateMu.RUnlock()
+	return f.historyRecords()
+}
+
+// Replay fires the events of the records in order, as recorded by History,
+// checking that the FSM is in the source state of each record before firing
+// its event and in the destination state after it. It stops at the first
+// divergence, returning a ReplayMismatchError. Events that do not change the
+// state are accepted if the source and destination states are the same. The
+// events are fired without arguments, like EventIf, and Replay must not be
+// called from within a callback.
+func (f *FSM) Replay(records []TransitionRecord) error {
+	for i, r := range records {
+		err := f.EventIf(r.Src, r.Event)
+		if e, ok := err.(UnexpectedStateError); ok {
+			return ReplayMismatchError{i, r.Event, r.Src, e.Actual, nil}
+		}
+		if _, ok := err.(NoTransitionError); ok && r.Src == r.Dst {
+			err = nil
+		}
+		if current := f.Current(); err != nil || current != r.Dst {
+			return ReplayMismatchError{i, r.Event, r.Dst, current, err}
+		}
+	}
+	return nil
+}
+
+// historyRecords returns a copy of the history in order. stateMu must be held.
+func (f *FSM) historyRecords() []TransitionRecord {
+	records := make([]TransitionRecord, 0, len(f.history))
+	if len(f.history) < f.historySize {
+		return append(records, f.history...)
+	}
+	records = append(records, f.history[f.historyNext:]...)
+	return append(records, f.history[:f.historyNext]...)
+}
+
+// record adds a completed transition to the history, if enabled. stateMu must
+// be held.
+func (f *FSM) record(e *Event) {
+	if f.historySize == 0 {
+		return
+	}
+	r := TransitionRecord{Event: e.Event, Src: e.Src, Dst: e.Dst, Time: time.Now()}
+	if len(f.history) < f.historySize {
+		f.history = append(f.history, r)
+	} else {
+		f.history[f.historyNext] = r
+	}
+	f.historyNext = (f.historyNext + 1) % f.historySize
+}
@@ -0,0 +1,145 @@
+// Copyright (c) 2013 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fsm
+
+import (
+	"testing"
+)
+
+func TestHistory(t *testing.T) {
+	fsm := NewFSM(
+		"green",
+		Events{
+			{Name: "warn", Src: []string{"green"}, Dst: "yellow"},
+			{Name: "panic", Src: []string{"yellow"}, Dst: "red"},
+			{Name: "calm", Src: []string{"red"}, Dst: "yellow"},
+		},
+		Callbacks{},
+	)
+	if len(fsm.History()) != 0 {
+		t.Error("expected history to be disabled by default")
+	}
+
+	fsm.SetHistorySize(10)
+	fsm.Event("warn")
+	fsm.Event("panic")
+	fsm.Event("calm")
+
+	expected := []TransitionRecord{
+		{Event: "warn", Src: "green", Dst: "yellow"},
+		{Event: "panic", Src: "yellow", Dst: "red"},
+		{Event: "calm", Src: "red", Dst: "yellow"},
+	}
+	history := fsm.History()
+	if len(history) != len(expected) {
+		t.Fatalf("expected %d records, got %d", len(expected), len(history))
+	}
+	for i, r := range history {
+		if r.Event != expected[i].Event || r.Src != expected[i].Src || r.Dst != expected[i].Dst {
+			t.Errorf("expected record %d to be %v, got %v", i, expected[i], r)
+		}
+		if i > 0 && r.Time.Before(history[i-1].Time) {
+			t.Errorf("expected record %d to be recorded after the previous one", i)
+		}
+	}
+}
+
+func TestHistoryRingBuffer(t *testing.T) {
+	fsm := NewFSM(
+		"closed",
+		Events{
+			{Name: "open", Src: []string{"closed"}, Dst: "open"},
+			{Name: "close", Src: []string{"open"}, Dst: "closed"},
+		},
+		Callbacks{},
+	)
+	fsm.SetHistorySize(2)
+	fsm.Event("open")
+	fsm.Event("close")
+	fsm.Event("open")
+
+	history := fsm.History()
+	if len(history) != 2 || history[0].Event != "close" || history[1].Event != "open" {
+		t.Errorf("expected the two latest records, got %v", history)
+	}
+
+	fsm.SetHistorySize(1)
+	history = fsm.History()
+	if len(history) != 1 || history[0].Event != "open" {
+		t.Errorf("expected the latest record to be kept, got %v", history)
+	}
+
+	fsm.SetHistorySize(0)
+	fsm.Event("close")
+	if len(fsm.History()) != 0 {
+		t.Error("expected history to be disabled")
+	}
+}
+
+func TestReplay(t *testing.T) {
+	events := Events{
+		{Name: "warn", Src: []string{"green"}, Dst: "yellow"},
+		{Name: "panic", Src: []string{"yellow"}, Dst: "red"},
+		{Name: "calm", Src: []string{"red"}, Dst: "yellow"},
+	}
+	recorded := NewFSM("green", events, Callbacks{})
+	recorded.SetHistorySize(10)
+	recorded.Event("warn")
+	recorded.Event("panic")
+	recorded.Event("calm")
+
+	fsm := NewFSM("green", events, Callbacks{})
+	if err := fsm.Replay(recorded.History()); err != nil {
+		t.Fatal(err)
+	}
+	if fsm.Current() != "yellow" {
+		t.Errorf("expected state to be 'yellow', got %s", fsm.Current())
+	}
+}
+
+func TestReplayMismatch(t *testing.T) {
+	fsm := NewFSM(
+		"green",
+		Events{
+			{Name: "warn", Src: []string{"green"}, Dst: "yellow"},
+			{Name: "panic", Src: []string{"yellow"}, Dst: "orange"},
+		},
+		Callbacks{},
+	)
+	records := []TransitionRecord{
+		{Event: "warn", Src: "green", Dst: "yellow"},
+		{Event: "panic", Src: "yellow", Dst: "red"},
+		{Event: "calm", Src: "red", Dst: "yellow"},
+	}
+	err := fsm.Replay(records)
+	e, ok := err.(ReplayMismatchError)
+	if !ok || e.Index != 1 || e.Event != "panic" || e.Expected != "red" || e.Actual != "orange" || e.Err != nil {
+		t.Errorf("expected ReplayMismatchError at record 1, got %v", err)
+	}
+
+	fsm.SetState("green")
+	err = fsm.Replay(records[1:])
+	e, ok = err.(ReplayMismatchError)
+	if !ok || e.Index != 0 || e.Expected != "yellow" || e.Actual != "green" {
+		t.Errorf("expected ReplayMismatchError for the source state, got %v", err)
+	}
+
+	fsm.SetState("red")
+	err = fsm.Replay(records[2:])
+	e, ok = err.(ReplayMismatchError)
+	if _, invalid := e.Err.(UnknownEventError); !ok || !invalid {
+		t.Errorf("expected ReplayMismatchError with UnknownEventError, got %v", err)
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright (c) 2013 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fsm
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// jsonState is the JSON representation of the state of a FSM.
+type jsonState struct {
+	// Current is the current state.
+	Current string `json:"current"`
+
+	// InTransition is true if an asynchronous transition was pending.
+	InTransition bool `json:"in_transition,omitempty"`
+}
+
+// MarshalJSON encodes the current state of the FSM, and whether an
+// asynchronous transition is pending, as JSON. The transitions and callbacks
+// are not encoded.
+func (f *FSM) MarshalJSON() ([]byte, error) {
+	f.stateMu.RLock()
+	defer f.stateMu.RUnlock()
+	return json.Marshal(jsonState{
+		Current:      f.current,
+		InTransition: f.transition != nil,
+	})
+}
+
+// UnmarshalJSON restores the current state of the FSM from JSON encoded with
+// MarshalJSON, like RestoreState.
+//
+// The FSM must have been created with NewFSM since only the current state is
+// restored, and it returns an UnknownStateError if the state is not used by
+// its transitions. A pending asynchronous transition can not be restored and
+// the FSM will be left in the source state of that transition. Any pending
+// transition of the FSM itself is cleared. No callbacks are called.
+func (f *FSM) UnmarshalJSON(data []byte) error {
+	if f.transitions == nil {
+		return UninitializedError{}
+	}
+	var s jsonState
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	return f.RestoreState(s.Current)
+}
+
+// jsonDefinition is the JSON representation of the definition of a FSM.
+type jsonDefinition struct {
+	// Initial is the initial state.
+	Initial string `json:"initial"`
+
+	// Transitions are the transitions of the FSM.
+	Transitions []jsonTransition `json:"transitions"`
+}
+
+// jsonTransition is the JSON representation of a transition.
+type jsonTransition struct {
+	// Event is the event name.
+	Event string `json:"event"`
+
+	// Src is the source state.
+	Src string `json:"src"`
+
+	// Dst is the destination state.
+	Dst string `json:"dst"`
+}
+
+// LoadJSON constructs a FSM from a JSON definition of the form:
+//
+//	{
+//	    "initial": "closed",
+//	    "transitions": [
+//	        {"event": "open", "src": "closed", "dst": "open"},
+//	        {"event": "close", "src": "open", "dst": "closed"}
+//	    ]
+//	}
+//
+// Callbacks can not be defined in JSON and are set on the returned FSM with
+// OnBeforeEvent, OnLeave, OnEnter and OnAfterEvent. A missing or empty field
+// gives a MissingFieldError, and the definition is then checked like with
+// NewFSMWithError.
+func LoadJSON(r io.Reader) (*FSM, error) {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	var d jsonDefinition
+	if err := dec.Decode(&d); err != nil {
+		return nil, err
+	}
+
+	if d.Initial == "" {
+		return nil, MissingFieldError{"initial"}
+	}
+	events := make(Events, 0, len(d.Transitions))
+	for i, t := range d.Transitions {
+		switch {
+		case t.Event == "":
+			return nil, MissingFieldError{fmt.Sprintf("transitions[%d].event", i)}
+		case t.Src == "":
+			return nil, MissingFieldError{fmt.Sprintf("transitions[%d].src", i)}
+		case t.Dst == "":
+			return nil, MissingFieldError{fmt.Sprintf("transitions[%d].dst", i)}
+		}
+		events = append(events, EventDesc{Name: t.Event, Src: []string{t.Src}, Dst: t.Dst})
+	}
+	return NewFSMWithError(d.Initial, events, Callbacks{})
+}
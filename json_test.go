@@ -0,0 +1,165 @@
+// Copyright (c) 2013 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fsm
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestJSONRoundTrip(t *testing.T) {
+	newDoor := func() *FSM {
+		return NewFSM(
+			"closed",
+			Events{
+				{Name: "open", Src: []string{"closed"}, Dst: "open"},
+				{Name: "close", Src: []string{"open"}, Dst: "closed"},
+			},
+			Callbacks{},
+		)
+	}
+
+	fsm := newDoor()
+	fsm.Event("open")
+	data, err := json.Marshal(fsm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"current":"open"}` {
+		t.Errorf("unexpected JSON: %s", data)
+	}
+
+	restored := newDoor()
+	if err := json.Unmarshal(data, restored); err != nil {
+		t.Fatal(err)
+	}
+	if restored.Current() != "open" {
+		t.Error("expected state to be 'open'")
+	}
+	if err := restored.Event("close"); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+}
+
+func TestJSONInTransition(t *testing.T) {
+	fsm := NewFSM(
+		"closed",
+		Events{
+			{Name: "open", Src: []string{"closed"}, Dst: "open"},
+		},
+		Callbacks{
+			"leave_closed": func(e *Event) {
+				e.Async()
+			},
+		},
+	)
+	fsm.Event("open")
+	data, err := json.Marshal(fsm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"current":"closed","in_transition":true}` {
+		t.Errorf("unexpected JSON: %s", data)
+	}
+}
+
+func TestJSONUnmarshalClearsTransition(t *testing.T) {
+	fsm := NewFSM(
+		"closed",
+		Events{
+			{Name: "open", Src: []string{"closed"}, Dst: "open"},
+			{Name: "lock", Src: []string{"closed"}, Dst: "locked"},
+		},
+		Callbacks{
+			"leave_closed": func(e *Event) {
+				e.Async()
+			},
+		},
+	)
+	fsm.Event("open")
+
+	if err := json.Unmarshal([]byte(`{"current":"locked"}`), fsm); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fsm.Transition().(NotInTransitionError); !ok {
+		t.Error("expected the pending transition to be cleared")
+	}
+	if fsm.Current() != "locked" {
+		t.Errorf("expected state to stay 'locked', got %s", fsm.Current())
+	}
+
+	err := json.Unmarshal([]byte(`{"current":"flying"}`), fsm)
+	if e, ok := err.(UnknownStateError); !ok || e.State != "flying" {
+		t.Errorf("expected 'UnknownStateError', got %v", err)
+	}
+}
+
+func TestJSONUninitialized(t *testing.T) {
+	var fsm FSM
+	err := json.Unmarshal([]byte(`{"current":"open"}`), &fsm)
+	if _, ok := err.(UninitializedError); !ok {
+		t.Errorf("expected 'UninitializedError', got %v", err)
+	}
+}
+
+func TestLoadJSON(t *testing.T) {
+	fsm, err := LoadJSON(strings.NewReader(`{
+		"initial": "closed",
+		"transitions": [
+			{"event": "open", "src": "closed", "dst": "open"},
+			{"event": "close", "src": "open", "dst": "closed"}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entered := false
+	fsm.OnEnter("open", func(e *Event) {
+		entered = true
+	})
+	if fsm.Current() != "closed" {
+		t.Error("expected state to be 'closed'")
+	}
+	if err := fsm.Event("open"); err != nil {
+		t.Fatal(err)
+	}
+	if fsm.Current() != "open" || !entered {
+		t.Error("expected to enter state 'open'")
+	}
+	if err := fsm.Event("close"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadJSONInvalid(t *testing.T) {
+	tests := []struct {
+		doc      string
+		expected string
+	}{
+		{`{"transitions": []}`, "missing field initial"},
+		{`{"initial": "a", "transitions": [{"event": "run", "src": "a", "dst": "b"}, {"src": "b", "dst": "a"}]}`, "missing field transitions[1].event"},
+		{`{"initial": "a", "transitions": [{"event": "run", "dst": "b"}]}`, "missing field transitions[0].src"},
+		{`{"initial": "a", "transitions": [{"event": "run", "src": "a"}]}`, "missing field transitions[0].dst"},
+		{`{"initial": "a", "states": []}`, `json: unknown field "states"`},
+		{`{"initial": "a", "transitions": [{"event": "run", "src": "a", "dst": "b"}, {"event": "run", "src": "a", "dst": "c"}]}`, "event run has conflicting transitions from state a"},
+	}
+	for _, test := range tests {
+		_, err := LoadJSON(strings.NewReader(test.doc))
+		if err == nil || err.Error() != test.expected {
+			t.Errorf("expected error %q for %s, got %v", test.expected, test.doc, err)
+		}
+	}
+}
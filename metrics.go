@@ -0,0 +1,91 @@
+// Copyright (c) 2013 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fsm
+
+import "time"
+
+// TransitionCount returns the number of transitions completed since the FSM
+// was constructed. Transitions that are rolled back are not counted.
+func (f *FSM) TransitionCount() uint64 {
+	f.stateMu.RLock()
+	defer f.stateMu.RUnlock()
+	return f.generation
+}
+
+// LastTransition returns when the last transition completed, or the zero time
+// if none has.
+func (f *FSM) LastTransition() time.Time {
+	f.stateMu.RLock()
+	defer f.stateMu.RUnlock()
+	return f.lastTransition
+}
+
+// StateEnteredAt returns when the current state was entered, by a transition,
+// SetState or any other change of the state, or when the FSM was constructed.
+func (f *FSM) StateEnteredAt() time.Time {
+	f.stateMu.RLock()
+	defer f.stateMu.RUnlock()
+	return f.enteredAt
+}
+
+// TimeInState returns how long the FSM has been in the current state, see
+// StateEnteredAt.
+func (f *FSM) TimeInState() time.Duration {
+	return time.Since(f.StateEnteredAt())
+}
+
+// SetMetricsEnabled sets whether the FSM counts how many times each state is
+// entered by a completed transition. Metrics are disabled by default.
+// Disabling them discards the counts.
+func (f *FSM) SetMetricsEnabled(enabled bool) {
+	f.stateMu.Lock()
+	defer f.stateMu.Unlock()
+
+	if !enabled {
+		f.visits = nil
+	} else if f.visits == nil {
+		f.visits = make(map[string]int)
+	}
+}
+
+// VisitCount returns how many times state has been entered since metrics were
+// enabled.
+func (f *FSM) VisitCount(state string) int {
+	f.stateMu.RLock()
+	defer f.stateMu.RUnlock()
+	return f.visits[f.normalize(state)]
+}
+
+// VisitCounts returns a copy of the number of times each state has been
+// entered since metrics were enabled. States that have not been entered are
+// left out.
+func (f *FSM) VisitCounts() map[string]int {
+	f.stateMu.RLock()
+	defer f.stateMu.RUnlock()
+
+	counts := make(map[string]int, len(f.visits))
+	for k, v := range f.visits {
+		counts[k] = v
+	}
+	return counts
+}
+
+// countVisit counts an entry into state, if metrics are enabled. stateMu must
+// be held.
+func (f *FSM) countVisit(state string) {
+	if f.visits != nil {
+		f.visits[state]++
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright (c) 2013 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fsm
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestVisitCounts(t *testing.T) {
+	fsm := NewFSM(
+		"green",
+		Events{
+			{Name: "warn", Src: []string{"green"}, Dst: "yellow"},
+			{Name: "panic", Src: []string{"yellow"}, Dst: "red"},
+			{Name: "calm", Src: []string{"red"}, Dst: "green"},
+		},
+		Callbacks{},
+	)
+	fsm.Event("warn")
+	if len(fsm.VisitCounts()) != 0 {
+		t.Error("expected no counts with metrics disabled")
+	}
+
+	fsm.SetMetricsEnabled(true)
+	for i := 0; i < 2; i++ {
+		for _, e := range []string{"panic", "calm", "warn"} {
+			if err := fsm.Event(e); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	fsm.Event("panic")
+
+	if fsm.VisitCount("red") != 3 {
+		t.Errorf("expected 3 visits to 'red', got %d", fsm.VisitCount("red"))
+	}
+	if fsm.VisitCount("unknown") != 0 {
+		t.Error("expected no visits to an unknown state")
+	}
+	counts := fsm.VisitCounts()
+	if fmt.Sprint(counts) != "map[green:2 red:3 yellow:2]" {
+		t.Errorf("unexpected visit counts %v", counts)
+	}
+
+	counts["red"] = 10
+	if fsm.VisitCount("red") != 3 {
+		t.Error("expected the returned counts to be a copy")
+	}
+
+	fsm.SetMetricsEnabled(false)
+	if fsm.VisitCount("red") != 0 {
+		t.Error("expected counts to be discarded when disabled")
+	}
+}
+
+func TestTransitionCount(t *testing.T) {
+	fsm := NewFSM(
+		"green",
+		Events{
+			{Name: "warn", Src: []string{"green"}, Dst: "yellow"},
+			{Name: "panic", Src: []string{"yellow"}, Dst: "red"},
+			{Name: "calm", Src: []string{"red"}, Dst: "green"},
+		},
+		Callbacks{},
+	)
+	if fsm.TransitionCount() != 0 || !fsm.LastTransition().IsZero() {
+		t.Error("expected no transitions")
+	}
+
+	before := time.Now()
+	for _, e := range []string{"warn", "panic", "calm", "warn"} {
+		if err := fsm.Event(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fsm.Event("calm")
+
+	if fsm.TransitionCount() != 4 {
+		t.Errorf("expected 4 transitions, got %d", fsm.TransitionCount())
+	}
+	if last := fsm.LastTransition(); last.Before(before) || last.After(time.Now()) {
+		t.Errorf("expected a recent last transition time, got %v", last)
+	}
+}
+
+func TestTimeInState(t *testing.T) {
+	fsm := NewFSM(
+		"green",
+		Events{
+			{Name: "warn", Src: []string{"green"}, Dst: "yellow"},
+		},
+		Callbacks{},
+	)
+
+	before := time.Now()
+	if err := fsm.Event("warn"); err != nil {
+		t.Fatal(err)
+	}
+	entered := fsm.StateEnteredAt()
+	if entered.Before(before) || entered.After(time.Now()) {
+		t.Errorf("expected a recent state entry time, got %v", entered)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if d := fsm.TimeInState(); d < 20*time.Millisecond {
+		t.Errorf("expected at least 20ms in state, got %v", d)
+	}
+
+	fsm.SetState("red")
+	if !fsm.StateEnteredAt().After(entered) {
+		t.Error("expected SetState to update the state entry time")
+	}
+	if d := fsm.TimeInState(); d >= 20*time.Millisecond {
+		t.Errorf("expected the time in state to restart, got %v", d)
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright (c) 2013 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fsm
+
+import "sort"
+
+// ReachableStates returns the states that can be reached from the current
+// state by any sequence of events, sorted. The current state is only included
+// if it can be reached again. Guards are not evaluated, final states are not
+// left and destinations picked by a Choose function are not known in advance
+// and are not followed.
+//
+// No callbacks are called and the FSM is not modified.
+func (f *FSM) ReachableStates() []string {
+	current, final := f.graphState()
+
+	reached := make(map[string]bool)
+	queue := []string{current}
+	for len(queue) > 0 {
+		state := queue[0]
+		queue = queue[1:]
+		if final[state] {
+			continue
+		}
+		for _, e := range f.successors(state) {
+			if !reached[e.dst] {
+				reached[e.dst] = true
+				queue = append(queue, e.dst)
+			}
+		}
+	}
+
+	states := make([]string, 0, len(reached))
+	for k := range reached {
+		states = append(states, k)
+	}
+	sort.Strings(states)
+	return states
+}
+
+// PathTo returns the shortest sequence of events that leads from the current
+// state to target, or NoPathError if there is none. Among paths of the same
+// length the one with the lowest event names is returned. The path to the
+// current state is empty. Guards, final states and Choose functions are
+// handled as in ReachableStates.
+//
+// No callbacks are called and the FSM is not modified.
+func (f *FSM) PathTo(target string) ([]string, error) {
+	target = f.normalize(target)
+	current, final := f.graphState()
+	if target == current {
+		return []string{}, nil
+	}
+
+	// prev holds the edge by which each state was first reached.
+	prev := map[string]edge{current: {}}
+	queue := []string{current}
+	for len(queue) > 0 {
+		state := queue[0]
+		queue = queue[1:]
+		if final[state] {
+			continue
+		}
+		for _, e := range f.successors(state) {
+			if _, ok := prev[e.dst]; ok {
+				continue
+			}
+			prev[e.dst] = e
+			if e.dst != target {
+				queue = append(queue, e.dst)
+				continue
+			}
+
+			var path []string
+			for s := target; s != current; s = prev[s].src {
+				path = append(path, prev[s].event)
+			}
+			for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+				path[i], path[j] = path[j], path[i]
+			}
+			return path, nil
+		}
+	}
+	return nil, NoPathError{current, target}
+}
+
+// graphState returns the current state and a copy of the final states.
+func (f *FSM) graphState() (string, map[string]bool) {
+	f.stateMu.RLock()
+	defer f.stateMu.RUnlock()
+	final := make(map[string]bool, len(f.final))
+	for k, v := range f.final {
+		final[k] = v
+	}
+	return f.current, final
+}
+
+// successors returns the transitions that can be taken from state, including
+// those from AnyState, sorted by event. Destinations without a name are left
+// out.
+func (f *FSM) successors(state string) []edge {
+	f.stateMu.RLock()
+	defer f.stateMu.RUnlock()
+	events := make(map[string]bool)
+	for k := range f.transitions {
+		if k.src == state || k.src == AnyState {
+			events[k.event] = true
+		}
+	}
+	names := make([]string, 0, len(events))
+	for k := range events {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var edges []edge
+	for _, event := range names {
+		dsts, _ := f.dsts(event, state)
+		for _, d := range dsts {
+			if d.dst != "" {
+				edges = append(edges, edge{src: state, event: event, dst: d.dst})
+			}
+		}
+	}
+	return edges
+}
@@ -0,0 +1,155 @@
+// Copyright (c) 2013 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fsm
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestReachableStatesChain(t *testing.T) {
+	fsm := NewFSM(
+		"a",
+		Events{
+			{Name: "next", Src: []string{"a"}, Dst: "b"},
+			{Name: "next", Src: []string{"b"}, Dst: "c"},
+			{Name: "next", Src: []string{"c"}, Dst: "d"},
+		},
+		Callbacks{},
+	)
+	if got := fmt.Sprint(fsm.ReachableStates()); got != "[b c d]" {
+		t.Errorf("expected reachable states [b c d], got %s", got)
+	}
+	fsm.SetState("c")
+	if got := fmt.Sprint(fsm.ReachableStates()); got != "[d]" {
+		t.Errorf("expected reachable states [d], got %s", got)
+	}
+	fsm.SetState("d")
+	if got := fmt.Sprint(fsm.ReachableStates()); got != "[]" {
+		t.Errorf("expected no reachable states, got %s", got)
+	}
+}
+
+func TestReachableStatesCycle(t *testing.T) {
+	called := false
+	fsm := NewFSM(
+		"green",
+		Events{
+			{Name: "warn", Src: []string{"green"}, Dst: "yellow"},
+			{Name: "panic", Src: []string{"yellow"}, Dst: "red"},
+			{Name: "calm", Src: []string{"red"}, Dst: "green"},
+			{Name: "break", Src: []string{"red"}, Dst: "broken"},
+		},
+		Callbacks{
+			"enter_state": func(e *Event) {
+				called = true
+			},
+		},
+	)
+	if got := fmt.Sprint(fsm.ReachableStates()); got != "[broken green red yellow]" {
+		t.Errorf("expected reachable states [broken green red yellow], got %s", got)
+	}
+	if called {
+		t.Error("expected no callbacks to be called")
+	}
+	if fsm.Current() != "green" {
+		t.Error("expected state to be 'green'")
+	}
+}
+
+func TestReachableStatesFinalAndAnyState(t *testing.T) {
+	fsm := NewFSM(
+		"a",
+		Events{
+			{Name: "next", Src: []string{"a"}, Dst: "b"},
+			{Name: "next", Src: []string{"b"}, Dst: "c"},
+			{Name: "fail", Src: []string{AnyState}, Dst: "failed"},
+		},
+		Callbacks{},
+	)
+	fsm.SetFinalStates("b", "failed")
+	if got := fmt.Sprint(fsm.ReachableStates()); got != "[b failed]" {
+		t.Errorf("expected reachable states [b failed], got %s", got)
+	}
+}
+
+func TestPathTo(t *testing.T) {
+	fsm := NewFSM(
+		"a",
+		Events{
+			{Name: "long", Src: []string{"a"}, Dst: "b"},
+			{Name: "long", Src: []string{"b"}, Dst: "c"},
+			{Name: "long", Src: []string{"c"}, Dst: "d"},
+			{Name: "short", Src: []string{"a"}, Dst: "c"},
+			{Name: "other", Src: []string{"a"}, Dst: "c"},
+			{Name: "back", Src: []string{"d"}, Dst: "a"},
+		},
+		Callbacks{},
+	)
+	path, err := fsm.PathTo("d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(path) != "[other long]" {
+		t.Errorf("expected path [other long], got %v", path)
+	}
+	if fsm.Current() != "a" {
+		t.Error("expected state to be 'a'")
+	}
+
+	for _, e := range path {
+		if err := fsm.Event(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if fsm.Current() != "d" {
+		t.Error("expected state to be 'd'")
+	}
+}
+
+func TestPathToUnreachable(t *testing.T) {
+	fsm := NewFSM(
+		"a",
+		Events{
+			{Name: "next", Src: []string{"a"}, Dst: "b"},
+			{Name: "next", Src: []string{"c"}, Dst: "a"},
+		},
+		Callbacks{},
+	)
+	path, err := fsm.PathTo("c")
+	if e, ok := err.(NoPathError); !ok || e.Src != "a" || e.Dst != "c" {
+		t.Errorf("expected 'NoPathError' from 'a' to 'c', got %v", err)
+	}
+	if path != nil {
+		t.Errorf("expected no path, got %v", path)
+	}
+}
+
+func TestPathToCurrent(t *testing.T) {
+	fsm := NewFSM(
+		"a",
+		Events{
+			{Name: "next", Src: []string{"a"}, Dst: "b"},
+		},
+		Callbacks{},
+	)
+	path, err := fsm.PathTo("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(path) != 0 {
+		t.Errorf("expected empty path, got %v", path)
+	}
+}
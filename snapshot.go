@@ -0,0 +1,56 @@
+// Copyright (c) 2013 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fsm
+
+import "sync/atomic"
+
+// StateSnapshot is an opaque token holding the current state of a FSM and the
+// number of state changes made when it was taken. It can only be restored on
+// the FSM it was taken from.
+type StateSnapshot struct {
+	fsm     *FSM
+	current string
+	changes uint64
+}
+
+// Snapshot returns a snapshot of the current state.
+func (f *FSM) Snapshot() StateSnapshot {
+	f.stateMu.RLock()
+	defer f.stateMu.RUnlock()
+	return StateSnapshot{f, f.current, f.changes}
+}
+
+// Restore checks that the FSM is still in the state of the snapshot. It
+// returns a StaleSnapshotError if the state has changed since the snapshot was
+// taken, by a transition or by SetState, RestoreState, Reset or UnmarshalJSON,
+// and a ForeignSnapshotError for a zero snapshot or one taken from another
+// FSM. Since any change makes the snapshot stale, Restore never changes the
+// state and calls no callbacks. Like SetState, it waits for any event being
+// fired unless it is called from within a callback of the event.
+func (f *FSM) Restore(s StateSnapshot) error {
+	if s.fsm != f {
+		return ForeignSnapshotError{}
+	}
+	if atomic.LoadInt32(&f.callbacksRunning) == 0 {
+		f.eventMu.Lock()
+		defer f.eventMu.Unlock()
+	}
+	f.stateMu.RLock()
+	defer f.stateMu.RUnlock()
+	if s.changes != f.changes || s.current != f.current {
+		return StaleSnapshotError{}
+	}
+	return nil
+}
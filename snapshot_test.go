@@ -0,0 +1,99 @@
+// Copyright (c) 2013 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fsm
+
+import (
+	"testing"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	fsm := NewFSM(
+		"closed",
+		Events{
+			{Name: "open", Src: []string{"closed"}, Dst: "open"},
+			{Name: "close", Src: []string{"open"}, Dst: "closed"},
+		},
+		Callbacks{},
+	)
+	s := fsm.Snapshot()
+	if err := fsm.Restore(s); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+	if fsm.Current() != "closed" {
+		t.Error("expected state to be 'closed'")
+	}
+}
+
+func TestSnapshotRestoreStaleSetState(t *testing.T) {
+	fsm := NewFSM(
+		"closed",
+		Events{
+			{Name: "open", Src: []string{"closed"}, Dst: "open"},
+			{Name: "close", Src: []string{"open"}, Dst: "closed"},
+		},
+		Callbacks{},
+	)
+	s := fsm.Snapshot()
+	fsm.SetState("open")
+	if _, ok := fsm.Restore(s).(StaleSnapshotError); !ok {
+		t.Error("expected 'StaleSnapshotError' after SetState")
+	}
+	if fsm.Current() != "open" {
+		t.Error("expected state to be 'open'")
+	}
+
+	s = fsm.Snapshot()
+	fsm.Reset(false)
+	if _, ok := fsm.Restore(s).(StaleSnapshotError); !ok {
+		t.Error("expected 'StaleSnapshotError' after Reset")
+	}
+}
+
+func TestSnapshotRestoreStale(t *testing.T) {
+	fsm := NewFSM(
+		"closed",
+		Events{
+			{Name: "open", Src: []string{"closed"}, Dst: "open"},
+			{Name: "close", Src: []string{"open"}, Dst: "closed"},
+		},
+		Callbacks{},
+	)
+	s := fsm.Snapshot()
+	fsm.Event("open")
+	if _, ok := fsm.Restore(s).(StaleSnapshotError); !ok {
+		t.Error("expected 'StaleSnapshotError'")
+	}
+	if fsm.Current() != "open" {
+		t.Error("expected state to be 'open'")
+	}
+}
+
+func TestSnapshotRestoreForeign(t *testing.T) {
+	events := Events{
+		{Name: "open", Src: []string{"closed"}, Dst: "open"},
+	}
+	fsm := NewFSM("closed", events, Callbacks{})
+	other := NewFSM("open", events, Callbacks{})
+
+	if _, ok := fsm.Restore(StateSnapshot{}).(ForeignSnapshotError); !ok {
+		t.Error("expected 'ForeignSnapshotError' for a zero snapshot")
+	}
+	if _, ok := fsm.Restore(other.Snapshot()).(ForeignSnapshotError); !ok {
+		t.Error("expected 'ForeignSnapshotError' for a snapshot of another FSM")
+	}
+	if fsm.Current() != "closed" {
+		t.Errorf("expected state to still be 'closed', got %s", fsm.Current())
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright (c) 2013 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fsm
+
+// subscriberBuffer is the number of state changes buffered for a subscriber.
+const subscriberBuffer = 16
+
+// StateChange is a completed transition sent to subscribers.
+type StateChange struct {
+	// From is the state before the transition.
+	From string
+
+	// To is the state after the transition.
+	To string
+
+	// Event is the event name.
+	Event string
+}
+
+// Subscribe returns a channel that receives every completed transition and a
+// function that unsubscribes and closes the channel. Each call returns a new
+// channel.
+//
+// The channel is buffered and the FSM never blocks on it: state changes are
+// dropped for a subscriber whose buffer is full. Transitions that are rolled
+// back are not sent.
+func (f *FSM) Subscribe() (<-chan StateChange, func()) {
+	ch := make(chan StateChange, subscriberBuffer)
+
+	f.stateMu.Lock()
+	f.subscribers = append(f.subscribers, ch)
+	f.stateMu.Unlock()
+
+	unsubscribe := func() {
+		f.stateMu.Lock()
+		defer f.stateMu.Unlock()
+		for i, s := range f.subscribers {
+			if s == ch {
+				f.subscribers = append(f.subscribers[:i], f.subscribers[i+1:]...)
+				close(ch)
+				return
+			}
+		}
+	}
+	return ch, unsubscribe
+}
+
+// publish sends the completed transition of the event to the subscribers
+// without blocking. stateMu must be held.
+func (f *FSM) publish(e *Event) {
+	change := StateChange{From: e.Src, To: e.Dst, Event: e.Event}
+	for _, ch := range f.subscribers {
+		select {
+		case ch <- change:
+		default:
+		}
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright (c) 2013 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fsm
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestSubscribe(t *testing.T) {
+	fsm := NewFSM(
+		"green",
+		Events{
+			{Name: "warn", Src: []string{"green"}, Dst: "yellow"},
+			{Name: "panic", Src: []string{"yellow"}, Dst: "red"},
+			{Name: "calm", Src: []string{"red"}, Dst: "yellow"},
+		},
+		Callbacks{},
+	)
+	ch1, unsubscribe1 := fsm.Subscribe()
+	ch2, unsubscribe2 := fsm.Subscribe()
+	defer unsubscribe2()
+
+	fsm.Event("warn")
+	fsm.Event("panic")
+	unsubscribe1()
+	unsubscribe1()
+	fsm.Event("calm")
+
+	var got1 []StateChange
+	for c := range ch1 {
+		got1 = append(got1, c)
+	}
+	if fmt.Sprint(got1) != "[{green yellow warn} {yellow red panic}]" {
+		t.Errorf("unexpected state changes for the first subscriber %v", got1)
+	}
+
+	var got2 []StateChange
+	for i := 0; i < 3; i++ {
+		got2 = append(got2, <-ch2)
+	}
+	if fmt.Sprint(got2) != "[{green yellow warn} {yellow red panic} {red yellow calm}]" {
+		t.Errorf("unexpected state changes for the second subscriber %v", got2)
+	}
+}
+
+func TestSubscribeSlowConsumer(t *testing.T) {
+	fsm := NewFSM(
+		"start",
+		Events{
+			{Name: "toggle", Src: []string{"start"}, Dst: "end"},
+			{Name: "toggle", Src: []string{"end"}, Dst: "start"},
+		},
+		Callbacks{},
+	)
+	ch, unsubscribe := fsm.Subscribe()
+	for i := 0; i < subscriberBuffer+5; i++ {
+		if err := fsm.Event("toggle"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	unsubscribe()
+
+	n := 0
+	for range ch {
+		n++
+	}
+	if n != subscriberBuffer {
+		t.Errorf("expected %d buffered state changes, got %d", subscriberBuffer, n)
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright (c) 2013 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fsm
+
+import (
+	"context"
+	"time"
+)
+
+// stateTimeout is the event fired after a timeout in a state.
+type stateTimeout struct {
+	d     time.Duration
+	event string
+}
+
+// SetStateTimeout fires event when the FSM has been in state for the duration
+// d. The timer starts when the state is entered and is stopped when the state
+// is left before the timeout. A duration of 0 or less removes the timeout.
+//
+// The timeout applies the next time the state is entered. Errors from firing
+// the event, for example if an asynchronous transition is pending, are only
+// reported to the error observer, if set.
+func (f *FSM) SetStateTimeout(state string, d time.Duration, event string) {
+	state = f.normalize(state)
+	f.stateMu.Lock()
+	defer f.stateMu.Unlock()
+	if d <= 0 {
+		delete(f.timeouts, state)
+		return
+	}
+	if f.timeouts == nil {
+		f.timeouts = make(map[string]stateTimeout)
+	}
+	f.timeouts[state] = stateTimeout{d, event}
+}
+
+// setCurrent sets the current state and the time it was entered, counting the
+// change, stopping the timeout timer of the previous state and starting the
+// one of the new state, and wakes up the WaitForState calls waiting for it.
+// stateMu must be held.
+func (f *FSM) setCurrent(state string) {
+	f.current = state
+	f.changes++
+	f.enteredAt = time.Now()
+	f.currentValue.Store(state)
+	f.notifyWaiters(state)
+	f.restartTimer()
+}
+
+// KickTimeout restarts the timeout timer of state if it is the current state
+// and has a running timer, like a watchdog, postponing the timeout event. It
+// returns false if there is no such timer. It can be called from callbacks.
+func (f *FSM) KickTimeout(state string) bool {
+	f.stateMu.Lock()
+	defer f.stateMu.Unlock()
+	if f.current != f.normalize(state) || f.timer == nil {
+		return false
+	}
+	f.restartTimer()
+	return true
+}
+
+// restartTimer stops the timeout timer, if any, and starts the one of the
+// current state. stateMu must be held.
+func (f *FSM) restartTimer() {
+	f.timerGen++
+	if f.timer != nil {
+		f.timer.Stop()
+		f.timer = nil
+	}
+	if t, ok := f.timeouts[f.current]; ok {
+		gen := f.timerGen
+		f.timer = time.AfterFunc(t.d, func() {
+			f.fireTimeout(gen, t.event)
+		})
+	}
+}
+
+// fireTimeout fires the timeout event unless the state has changed since the
+// timer was started.
+func (f *FSM) fireTimeout(gen uint64, event string) {
+	owner := f.startProcessing()
+	f.fireTimeoutEvent(gen, event)
+	if owner {
+		f.drainQueue()
+	}
+}
+
+// fireTimeoutEvent fires the timeout event for fireTimeout with eventMu held.
+func (f *FSM) fireTimeoutEvent(gen uint64, event string) {
+	f.eventMu.Lock()
+	defer f.eventMu.Unlock()
+
+	f.stateMu.RLock()
+	stale := gen != f.timerGen
+	f.stateMu.RUnlock()
+	if !stale {
+		f.fireNext(f.event(context.Background(), event, nil))
+	}
+}
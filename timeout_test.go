@@ -0,0 +1,109 @@
+// Copyright (c) 2013 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fsm
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStateTimeout(t *testing.T) {
+	done := make(chan struct{})
+	fsm := NewFSM(
+		"idle",
+		Events{
+			{Name: "start", Src: []string{"idle"}, Dst: "pending"},
+			{Name: "timeout", Src: []string{"pending"}, Dst: "failed"},
+		},
+		Callbacks{
+			"enter_failed": func(e *Event) {
+				close(done)
+			},
+		},
+	)
+	fsm.SetStateTimeout("pending", 10*time.Millisecond, "timeout")
+	fsm.Event("start")
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected the timeout event to be fired")
+	}
+	if fsm.Current() != "failed" {
+		t.Error("expected state to be 'failed'")
+	}
+}
+
+func TestStateTimeoutCanceled(t *testing.T) {
+	fsm := NewFSM(
+		"idle",
+		Events{
+			{Name: "start", Src: []string{"idle"}, Dst: "pending"},
+			{Name: "finish", Src: []string{"pending"}, Dst: "done"},
+			{Name: "timeout", Src: []string{AnyState}, Dst: "failed"},
+		},
+		Callbacks{},
+	)
+	fsm.SetStateTimeout("pending", 20*time.Millisecond, "timeout")
+	fsm.Event("start")
+	if err := fsm.Event("finish"); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if fsm.Current() != "done" {
+		t.Error("expected the timeout to be canceled when leaving the state")
+	}
+}
+
+func TestKickTimeout(t *testing.T) {
+	done := make(chan struct{})
+	fsm := NewFSM(
+		"idle",
+		Events{
+			{Name: "connect", Src: []string{"idle"}, Dst: "connected"},
+			{Name: "expire", Src: []string{"connected"}, Dst: "expired"},
+		},
+		Callbacks{
+			"enter_expired": func(e *Event) {
+				close(done)
+			},
+		},
+	)
+	if fsm.KickTimeout("idle") {
+		t.Error("expected no timer to kick in 'idle'")
+	}
+	fsm.SetStateTimeout("connected", 50*time.Millisecond, "expire")
+	fsm.Event("connect")
+
+	for i := 0; i < 30; i++ {
+		time.Sleep(5 * time.Millisecond)
+		if !fsm.KickTimeout("connected") {
+			t.Fatal("expected the timer to be kicked")
+		}
+	}
+	if fsm.Current() != "connected" {
+		t.Fatal("expected the timeout not to fire while kicked")
+	}
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected the timeout event to be fired after the kicks stop")
+	}
+	if fsm.KickTimeout("connected") {
+		t.Error("expected no timer to kick after leaving the state")
+	}
+}
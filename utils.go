@@ -2,40 +2,191 @@ package fsm
 
 import (
 	"bytes"
+	"encoding/xml"
 	"fmt"
+	"sort"
+	"strings"
 )
 
+// VisualizeOptions controls the styling of VisualizeWithOptions.
+type VisualizeOptions struct {
+	// HighlightCurrent fills the current state with a color.
+	HighlightCurrent bool
+
+	// MarkTerminal draws states without outgoing transitions with a double
+	// border.
+	MarkTerminal bool
+
+	// RankDir sets the direction of the graph layout, for example "LR" to lay
+	// it out from left to right. Empty leaves the Graphviz default.
+	RankDir string
+
+	// StateShape sets the shape of the states, for example "box". Empty leaves
+	// the Graphviz default.
+	StateShape string
+
+	// CurrentColor is the fill color of the current state if HighlightCurrent
+	// is set. Empty means lightblue.
+	CurrentColor string
+}
+
 // Visualize outputs a visualization of a FSM in Graphviz format.
+//
+// The output is deterministic, with the transitions from the current state
+// first and all transitions and states sorted by name.
 func Visualize(fsm *FSM) string {
+	return VisualizeWithOptions(fsm, VisualizeOptions{})
+}
+
+// VisualizeWithOptions outputs a visualization of a FSM in Graphviz format like
+// Visualize, styled by the options.
+func VisualizeWithOptions(fsm *FSM, opts VisualizeOptions) string {
 	var buf bytes.Buffer
 
-	states := make(map[string]int)
+	current := fsm.Current()
+	edges := sortedEdges(fsm)
 
 	buf.WriteString(fmt.Sprintf(`digraph fsm {`))
 	buf.WriteString("\n")
+	if opts.RankDir != "" {
+		buf.WriteString(fmt.Sprintf(`    rankdir = "%s";`, opts.RankDir))
+		buf.WriteString("\n")
+	}
+	if opts.StateShape != "" {
+		buf.WriteString(fmt.Sprintf(`    node [ shape = "%s" ];`, opts.StateShape))
+		buf.WriteString("\n")
+	}
 
-	// make sure the initial state is at top
-	for k, v := range fsm.transitions {
-		if k.src == fsm.current {
-			states[k.src]++
-			states[v]++
-			buf.WriteString(fmt.Sprintf(`    "%s" -> "%s" [ label = "%s" ];`, k.src, v, k.event))
+	states := writeEdges(&buf, current, edges)
+
+	buf.WriteString("\n")
+
+	srcs := make(map[string]bool)
+	for _, e := range edges {
+		srcs[e.src] = true
+	}
+
+	for _, k := range states {
+		var attrs []string
+		if opts.HighlightCurrent && k == current {
+			color := opts.CurrentColor
+			if color == "" {
+				color = "lightblue"
+			}
+			attrs = append(attrs, "style = filled", "fillcolor = "+color)
+		}
+		if opts.MarkTerminal && !srcs[k] && !srcs[AnyState] {
+			attrs = append(attrs, "peripheries = 2")
+		}
+		if len(attrs) > 0 {
+			buf.WriteString(fmt.Sprintf(`    "%s" [ %s ];`, k, strings.Join(attrs, ", ")))
+		} else {
+			buf.WriteString(fmt.Sprintf(`    "%s";`, k))
+		}
+		buf.WriteString("\n")
+	}
+	buf.WriteString(fmt.Sprintln("}"))
+
+	return buf.String()
+}
+
+// VisualizeGrouped outputs a visualization of a FSM in Graphviz format like
+// Visualize, with the states of each group drawn together in a cluster
+// labelled with the group name. It returns a GroupConflictError if a state is
+// in more than one group.
+func VisualizeGrouped(fsm *FSM, groups map[string][]string) (string, error) {
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	grouped := make(map[string]bool)
+	for _, name := range names {
+		for _, state := range groups[name] {
+			if grouped[state] {
+				return "", GroupConflictError{state}
+			}
+			grouped[state] = true
+		}
+	}
+
+	var buf bytes.Buffer
+
+	buf.WriteString(fmt.Sprintf(`digraph fsm {`))
+	buf.WriteString("\n")
+
+	states := writeEdges(&buf, fsm.Current(), sortedEdges(fsm))
+
+	buf.WriteString("\n")
+
+	for _, name := range names {
+		buf.WriteString(fmt.Sprintf(`    subgraph "cluster_%s" {`, name))
+		buf.WriteString("\n")
+		buf.WriteString(fmt.Sprintf(`        label = "%s";`, name))
+		buf.WriteString("\n")
+		groupStates := append([]string(nil), groups[name]...)
+		sort.Strings(groupStates)
+		for _, k := range groupStates {
+			buf.WriteString(fmt.Sprintf(`        "%s";`, k))
 			buf.WriteString("\n")
 		}
+		buf.WriteString("    }\n")
 	}
 
-	for k, v := range fsm.transitions {
-		if k.src != fsm.current {
-			states[k.src]++
-			states[v]++
-			buf.WriteString(fmt.Sprintf(`    "%s" -> "%s" [ label = "%s" ];`, k.src, v, k.event))
+	for _, k := range states {
+		if !grouped[k] {
+			buf.WriteString(fmt.Sprintf(`    "%s";`, k))
 			buf.WriteString("\n")
 		}
 	}
+	buf.WriteString(fmt.Sprintln("}"))
+
+	return buf.String(), nil
+}
 
+// VisualizeMerged outputs a visualization of a FSM in Graphviz format like
+// Visualize, with the transitions between the same source and destination
+// states merged into one edge labelled with their events, sorted and comma
+// separated.
+func VisualizeMerged(fsm *FSM) string {
+	type pair struct{ src, dst string }
+	events := make(map[pair][]string)
+	var pairs []pair
+	for _, t := range fsm.TransitionTable() {
+		if t.Dst == "" {
+			continue
+		}
+		p := pair{t.Src, t.Dst}
+		if _, ok := events[p]; !ok {
+			pairs = append(pairs, p)
+		}
+		if n := len(events[p]); n == 0 || events[p][n-1] != t.Event {
+			events[p] = append(events[p], t.Event)
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i].src != pairs[j].src {
+			return pairs[i].src < pairs[j].src
+		}
+		return pairs[i].dst < pairs[j].dst
+	})
+
+	edges := make([]edge, 0, len(pairs))
+	for _, p := range pairs {
+		edges = append(edges, edge{src: p.src, event: strings.Join(events[p], ", "), dst: p.dst})
+	}
+
+	var buf bytes.Buffer
+
+	buf.WriteString(fmt.Sprintf(`digraph fsm {`))
 	buf.WriteString("\n")
 
-	for k := range states {
+	states := writeEdges(&buf, fsm.Current(), edges)
+
+	buf.WriteString("\n")
+
+	for _, k := range states {
 		buf.WriteString(fmt.Sprintf(`    "%s";`, k))
 		buf.WriteString("\n")
 	}
@@ -43,3 +194,244 @@ func Visualize(fsm *FSM) string {
 
 	return buf.String()
 }
+
+// writeEdges writes the transitions in Graphviz format, those from the current
+// state first, and returns the sorted states used by them.
+func writeEdges(buf *bytes.Buffer, current string, edges []edge) []string {
+	states := make(map[string]int)
+
+	// make sure the initial state is at top
+	for _, e := range edges {
+		if e.src == current {
+			states[e.src]++
+			states[e.dst]++
+			writeEdge(buf, e)
+		}
+	}
+
+	for _, e := range edges {
+		if e.src != current {
+			states[e.src]++
+			states[e.dst]++
+			writeEdge(buf, e)
+		}
+	}
+
+	sortedStates := make([]string, 0, len(states))
+	for k := range states {
+		sortedStates = append(sortedStates, k)
+	}
+	sort.Strings(sortedStates)
+	return sortedStates
+}
+
+// writeEdge writes a transition in Graphviz format. Transitions from AnyState
+// are drawn dashed, and guarded transitions and transitions with a Choose
+// function are labelled as such.
+func writeEdge(buf *bytes.Buffer, e edge) {
+	label := e.event
+	if e.guarded {
+		label += " [guarded]"
+	}
+	if e.dynamic {
+		label += " [dynamic]"
+	}
+	if e.src == AnyState {
+		buf.WriteString(fmt.Sprintf(`    "%s" -> "%s" [ label = "%s", style = dashed ];`, e.src, e.dst, label))
+	} else {
+		buf.WriteString(fmt.Sprintf(`    "%s" -> "%s" [ label = "%s" ];`, e.src, e.dst, label))
+	}
+	buf.WriteString("\n")
+}
+
+// VisualizeMermaid outputs a visualization of a FSM in Mermaid state diagram
+// format. Transitions from AnyState are drawn from every state that has no
+// transition of its own for the event.
+func VisualizeMermaid(fsm *FSM) string {
+	var buf bytes.Buffer
+
+	buf.WriteString("stateDiagram-v2\n")
+	buf.WriteString(fmt.Sprintf("    [*] --> %s\n", fsm.Current()))
+
+	for _, e := range expandedEdges(fsm) {
+		buf.WriteString(fmt.Sprintf("    %s --> %s : %s\n", e.src, e.dst, e.event))
+	}
+
+	return buf.String()
+}
+
+// VisualizePlantUML outputs a visualization of a FSM in PlantUML state diagram
+// format, with the current state highlighted. Transitions from AnyState are
+// drawn from every state that has no transition of its own for the event.
+func VisualizePlantUML(fsm *FSM) string {
+	var buf bytes.Buffer
+
+	buf.WriteString("@startuml\n")
+	buf.WriteString(fmt.Sprintf("[*] --> %s\n", fsm.initialState()))
+	buf.WriteString(fmt.Sprintf("state %s #lightblue\n", fsm.Current()))
+
+	for _, e := range expandedEdges(fsm) {
+		buf.WriteString(fmt.Sprintf("%s --> %s : %s\n", e.src, e.dst, e.event))
+	}
+
+	buf.WriteString("@enduml\n")
+
+	return buf.String()
+}
+
+// VisualizeSCXML outputs the FSM as a SCXML document, with a state element for
+// every state, sorted, and the initial state as the initial attribute.
+// Transitions from AnyState are written for every state that has no
+// transition of its own for the event.
+func VisualizeSCXML(fsm *FSM) string {
+	var buf bytes.Buffer
+
+	transitions := make(map[string][]edge)
+	for _, e := range expandedEdges(fsm) {
+		transitions[e.src] = append(transitions[e.src], e)
+	}
+	for _, edges := range transitions {
+		sort.SliceStable(edges, func(i, j int) bool {
+			return edges[i].event < edges[j].event
+		})
+	}
+
+	buf.WriteString(xml.Header)
+	buf.WriteString(fmt.Sprintf(`<scxml xmlns="http://www.w3.org/2005/07/scxml" version="1.0" initial="%s">`, xmlEscape(fsm.initialState())))
+	buf.WriteString("\n")
+	for _, state := range fsm.States() {
+		if len(transitions[state]) == 0 {
+			buf.WriteString(fmt.Sprintf(`    <state id="%s"/>`, xmlEscape(state)))
+			buf.WriteString("\n")
+			continue
+		}
+		buf.WriteString(fmt.Sprintf(`    <state id="%s">`, xmlEscape(state)))
+		buf.WriteString("\n")
+		for _, e := range transitions[state] {
+			buf.WriteString(fmt.Sprintf(`        <transition event="%s" target="%s"/>`, xmlEscape(e.event), xmlEscape(e.dst)))
+			buf.WriteString("\n")
+		}
+		buf.WriteString("    </state>\n")
+	}
+	buf.WriteString("</scxml>\n")
+
+	return buf.String()
+}
+
+// VisualizeGo outputs the FSM as a Go expression constructing it with NewFSM,
+// for example to turn a FSM built at runtime into code. Transitions with the
+// same event and destination are combined into one EventDesc, sorted by event
+// and destination. The callbacks, guards, Choose functions and actions are
+// left out.
+func VisualizeGo(fsm *FSM) string {
+	type key struct{ event, dst string }
+	srcs := make(map[key][]string)
+	var keys []key
+	for _, e := range sortedEdges(fsm) {
+		k := key{e.event, e.dst}
+		if _, ok := srcs[k]; !ok {
+			keys = append(keys, k)
+		}
+		if n := len(srcs[k]); n == 0 || srcs[k][n-1] != e.src {
+			srcs[k] = append(srcs[k], e.src)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].event != keys[j].event {
+			return keys[i].event < keys[j].event
+		}
+		return keys[i].dst < keys[j].dst
+	})
+
+	var buf bytes.Buffer
+
+	buf.WriteString("fsm.NewFSM(\n")
+	buf.WriteString(fmt.Sprintf("\t%q,\n", fsm.initialState()))
+	buf.WriteString("\tfsm.Events{\n")
+	for _, k := range keys {
+		quoted := make([]string, len(srcs[k]))
+		for i, src := range srcs[k] {
+			quoted[i] = fmt.Sprintf("%q", src)
+		}
+		buf.WriteString(fmt.Sprintf("\t\t{Name: %q, Src: []string{%s}, Dst: %q},\n", k.event, strings.Join(quoted, ", "), k.dst))
+	}
+	buf.WriteString("\t},\n")
+	buf.WriteString("\tfsm.Callbacks{},\n")
+	buf.WriteString(")\n")
+
+	return buf.String()
+}
+
+// xmlEscape escapes s for use in XML text and attribute values.
+func xmlEscape(s string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
+
+// edge is a single transition of a FSM used for visualization.
+type edge struct {
+	src   string
+	event string
+	dst   string
+
+	// guarded is true if the transition has a guard.
+	guarded bool
+	// dynamic is true if the destination is picked by a Choose function.
+	dynamic bool
+}
+
+// sortedEdges returns all transitions of a FSM sorted by source state, event
+// and destination state. Transitions without a static destination are left
+// out.
+func sortedEdges(fsm *FSM) []edge {
+	fsm.stateMu.RLock()
+	defer fsm.stateMu.RUnlock()
+	return collectEdges(fsm)
+}
+
+// collectEdges returns the transitions for sortedEdges. stateMu must be held.
+func collectEdges(fsm *FSM) []edge {
+	var edges []edge
+	for k, dsts := range fsm.transitions {
+		for _, v := range dsts {
+			if v.dst == "" {
+				continue
+			}
+			edges = append(edges, edge{k.src, k.event, v.dst, v.guard != nil, v.choose != nil})
+		}
+	}
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].src != edges[j].src {
+			return edges[i].src < edges[j].src
+		}
+		if edges[i].event != edges[j].event {
+			return edges[i].event < edges[j].event
+		}
+		return edges[i].dst < edges[j].dst
+	})
+	return edges
+}
+
+// expandedEdges returns the transitions of a FSM like sortedEdges, with each
+// transition from AnyState replaced by transitions from every state that has
+// no transition of its own for the event.
+func expandedEdges(fsm *FSM) []edge {
+	fsm.stateMu.RLock()
+	defer fsm.stateMu.RUnlock()
+	var edges []edge
+	states := fsm.sortedStates()
+	for _, e := range collectEdges(fsm) {
+		if e.src != AnyState {
+			edges = append(edges, e)
+			continue
+		}
+		for _, src := range states {
+			if _, ok := fsm.transitions[eKey{e.event, src}]; !ok {
+				e.src = src
+				edges = append(edges, e)
+			}
+		}
+	}
+	return edges
+}
@@ -0,0 +1,372 @@
+package fsm
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+)
+
+func TestVisualize(t *testing.T) {
+	fsm := NewFSM(
+		"closed",
+		Events{
+			{Name: "open", Src: []string{"closed"}, Dst: "open"},
+			{Name: "close", Src: []string{"open"}, Dst: "closed"},
+			{Name: "kick", Src: []string{"closed", "open"}, Dst: "broken"},
+			{Name: "fix", Src: []string{"broken"}, Dst: "closed"},
+		},
+		Callbacks{},
+	)
+
+	expected := `digraph fsm {
+    "closed" -> "broken" [ label = "kick" ];
+    "closed" -> "open" [ label = "open" ];
+    "broken" -> "closed" [ label = "fix" ];
+    "open" -> "closed" [ label = "close" ];
+    "open" -> "broken" [ label = "kick" ];
+
+    "broken";
+    "closed";
+    "open";
+}
+`
+	got := Visualize(fsm)
+	if got != expected {
+		t.Errorf("unexpected Graphviz output:\n%s", got)
+	}
+	if again := Visualize(fsm); again != got {
+		t.Error("expected Visualize output to be deterministic")
+	}
+}
+
+func TestVisualizeGuards(t *testing.T) {
+	fsm := NewFSM(
+		"closed",
+		Events{
+			{Name: "open", Src: []string{"closed"}, Dst: "open", Guard: func(e *Event) bool {
+				return true
+			}},
+			{Name: "close", Src: []string{"open"}, Dst: "closed"},
+			{Name: "route", Src: []string{"open"}, Dst: "closed", Choose: func(e *Event) string {
+				return "closed"
+			}},
+		},
+		Callbacks{},
+	)
+
+	expected := `digraph fsm {
+    "closed" -> "open" [ label = "open [guarded]" ];
+    "open" -> "closed" [ label = "close" ];
+    "open" -> "closed" [ label = "route [dynamic]" ];
+
+    "closed";
+    "open";
+}
+`
+	got := Visualize(fsm)
+	if got != expected {
+		t.Errorf("unexpected Graphviz output:\n%s", got)
+	}
+}
+
+func TestVisualizeWithOptions(t *testing.T) {
+	fsm := NewFSM(
+		"closed",
+		Events{
+			{Name: "open", Src: []string{"closed"}, Dst: "open"},
+			{Name: "close", Src: []string{"open"}, Dst: "closed"},
+			{Name: "kick", Src: []string{"closed"}, Dst: "broken"},
+		},
+		Callbacks{},
+	)
+
+	plain := `digraph fsm {
+    "closed" -> "broken" [ label = "kick" ];
+    "closed" -> "open" [ label = "open" ];
+    "open" -> "closed" [ label = "close" ];
+
+    "broken";
+    "closed";
+    "open";
+}
+`
+	if got := VisualizeWithOptions(fsm, VisualizeOptions{}); got != plain {
+		t.Errorf("unexpected plain Graphviz output:\n%s", got)
+	}
+
+	styled := `digraph fsm {
+    "closed" -> "broken" [ label = "kick" ];
+    "closed" -> "open" [ label = "open" ];
+    "open" -> "closed" [ label = "close" ];
+
+    "broken" [ peripheries = 2 ];
+    "closed" [ style = filled, fillcolor = lightblue ];
+    "open";
+}
+`
+	opts := VisualizeOptions{HighlightCurrent: true, MarkTerminal: true}
+	if got := VisualizeWithOptions(fsm, opts); got != styled {
+		t.Errorf("unexpected styled Graphviz output:\n%s", got)
+	}
+
+	layout := `digraph fsm {
+    rankdir = "LR";
+    node [ shape = "box" ];
+    "closed" -> "broken" [ label = "kick" ];
+    "closed" -> "open" [ label = "open" ];
+    "open" -> "closed" [ label = "close" ];
+
+    "broken";
+    "closed" [ style = filled, fillcolor = yellow ];
+    "open";
+}
+`
+	opts = VisualizeOptions{HighlightCurrent: true, RankDir: "LR", StateShape: "box", CurrentColor: "yellow"}
+	if got := VisualizeWithOptions(fsm, opts); got != layout {
+		t.Errorf("unexpected LR Graphviz output:\n%s", got)
+	}
+}
+
+func TestVisualizeMerged(t *testing.T) {
+	fsm := NewFSM(
+		"closed",
+		Events{
+			{Name: "open", Src: []string{"closed"}, Dst: "open"},
+			{Name: "kick", Src: []string{"closed"}, Dst: "open"},
+			{Name: "close", Src: []string{"open"}, Dst: "closed"},
+			{Name: "break", Src: []string{"open"}, Dst: "broken"},
+		},
+		Callbacks{},
+	)
+
+	expected := `digraph fsm {
+    "closed" -> "open" [ label = "kick, open" ];
+    "open" -> "broken" [ label = "break" ];
+    "open" -> "closed" [ label = "close" ];
+
+    "broken";
+    "closed";
+    "open";
+}
+`
+	if got := VisualizeMerged(fsm); got != expected {
+		t.Errorf("unexpected merged Graphviz output:\n%s", got)
+	}
+}
+
+func TestVisualizeGo(t *testing.T) {
+	fsm := NewFSM(
+		"closed",
+		Events{
+			{Name: "open", Src: []string{"closed"}, Dst: "open"},
+			{Name: "close", Src: []string{"open"}, Dst: "closed"},
+			{Name: "break", Src: []string{"open", "closed"}, Dst: "broken"},
+			{Name: "ping", Src: []string{"open"}, Action: func(e *Event) {}},
+		},
+		Callbacks{},
+	)
+
+	expected := "fsm.NewFSM(\n" +
+		"\t\"closed\",\n" +
+		"\tfsm.Events{\n" +
+		"\t\t{Name: \"break\", Src: []string{\"closed\", \"open\"}, Dst: \"broken\"},\n" +
+		"\t\t{Name: \"close\", Src: []string{\"open\"}, Dst: \"closed\"},\n" +
+		"\t\t{Name: \"open\", Src: []string{\"closed\"}, Dst: \"open\"},\n" +
+		"\t},\n" +
+		"\tfsm.Callbacks{},\n" +
+		")\n"
+	if got := VisualizeGo(fsm); got != expected {
+		t.Errorf("unexpected Go output:\n%s", got)
+	}
+}
+
+func TestVisualizeGrouped(t *testing.T) {
+	fsm := NewFSM(
+		"idle",
+		Events{
+			{Name: "start", Src: []string{"idle"}, Dst: "running"},
+			{Name: "pause", Src: []string{"running"}, Dst: "paused"},
+			{Name: "resume", Src: []string{"paused"}, Dst: "running"},
+			{Name: "fail", Src: []string{"running", "paused"}, Dst: "failed"},
+			{Name: "reset", Src: []string{"failed"}, Dst: "idle"},
+		},
+		Callbacks{},
+	)
+
+	expected := `digraph fsm {
+    "idle" -> "running" [ label = "start" ];
+    "failed" -> "idle" [ label = "reset" ];
+    "paused" -> "failed" [ label = "fail" ];
+    "paused" -> "running" [ label = "resume" ];
+    "running" -> "failed" [ label = "fail" ];
+    "running" -> "paused" [ label = "pause" ];
+
+    subgraph "cluster_active" {
+        label = "active";
+        "paused";
+        "running";
+    }
+    subgraph "cluster_error" {
+        label = "error";
+        "failed";
+    }
+    "idle";
+}
+`
+	got, err := VisualizeGrouped(fsm, map[string][]string{
+		"active": {"running", "paused"},
+		"error":  {"failed"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != expected {
+		t.Errorf("unexpected Graphviz output:\n%s", got)
+	}
+
+	_, err = VisualizeGrouped(fsm, map[string][]string{
+		"active": {"running", "paused"},
+		"error":  {"failed", "paused"},
+	})
+	if e, ok := err.(GroupConflictError); !ok || e.State != "paused" {
+		t.Errorf("expected 'GroupConflictError' for 'paused', got %v", err)
+	}
+}
+
+func TestVisualizeMermaid(t *testing.T) {
+	fsm := NewFSM(
+		"closed",
+		Events{
+			{Name: "open", Src: []string{"closed"}, Dst: "open"},
+			{Name: "close", Src: []string{"open"}, Dst: "closed"},
+			{Name: "kick", Src: []string{AnyState}, Dst: "broken"},
+		},
+		Callbacks{},
+	)
+
+	expected := `stateDiagram-v2
+    [*] --> closed
+    broken --> broken : kick
+    closed --> broken : kick
+    open --> broken : kick
+    closed --> open : open
+    open --> closed : close
+`
+	if got := VisualizeMermaid(fsm); got != expected {
+		t.Errorf("unexpected Mermaid output:\n%s", got)
+	}
+}
+
+func TestVisualizePlantUML(t *testing.T) {
+	fsm := NewFSM(
+		"closed",
+		Events{
+			{Name: "open", Src: []string{"closed"}, Dst: "open"},
+			{Name: "close", Src: []string{"open"}, Dst: "closed"},
+			{Name: "kick", Src: []string{AnyState}, Dst: "broken"},
+		},
+		Callbacks{},
+	)
+	fsm.Event("open")
+
+	expected := `@startuml
+[*] --> closed
+state open #lightblue
+broken --> broken : kick
+closed --> broken : kick
+open --> broken : kick
+closed --> open : open
+open --> closed : close
+@enduml
+`
+	if got := VisualizePlantUML(fsm); got != expected {
+		t.Errorf("unexpected PlantUML output:\n%s", got)
+	}
+}
+
+func TestVisualizeConcurrentEvents(t *testing.T) {
+	fsm := NewFSM(
+		"closed",
+		Events{
+			{Name: "open", Src: []string{"closed"}, Dst: "open"},
+			{Name: "close", Src: []string{"open"}, Dst: "closed"},
+		},
+		Callbacks{},
+	)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			fsm.Event("open")
+			fsm.Event("close")
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		VisualizePlantUML(fsm)
+		Visualize(fsm)
+		VisualizeWithOptions(fsm, VisualizeOptions{HighlightCurrent: true})
+		VisualizeGrouped(fsm, nil)
+		VisualizeMerged(fsm)
+		VisualizeMermaid(fsm)
+		VisualizeSCXML(fsm)
+		VisualizeGo(fsm)
+	}
+	<-done
+}
+
+func TestVisualizeSCXML(t *testing.T) {
+	fsm := NewFSM(
+		"closed",
+		Events{
+			{Name: "open", Src: []string{"closed"}, Dst: "open"},
+			{Name: "close", Src: []string{"open"}, Dst: "closed"},
+			{Name: "kick", Src: []string{AnyState}, Dst: "broken"},
+			{Name: "kick", Src: []string{"broken"}, Dst: "broken & bent"},
+		},
+		Callbacks{},
+	)
+
+	expected := `<?xml version="1.0" encoding="UTF-8"?>
+<scxml xmlns="http://www.w3.org/2005/07/scxml" version="1.0" initial="closed">
+    <state id="broken">
+        <transition event="kick" target="broken &amp; bent"/>
+    </state>
+    <state id="broken &amp; bent">
+        <transition event="kick" target="broken"/>
+    </state>
+    <state id="closed">
+        <transition event="kick" target="broken"/>
+        <transition event="open" target="open"/>
+    </state>
+    <state id="open">
+        <transition event="close" target="closed"/>
+        <transition event="kick" target="broken"/>
+    </state>
+</scxml>
+`
+	got := VisualizeSCXML(fsm)
+	if got != expected {
+		t.Errorf("unexpected SCXML output:\n%s", got)
+	}
+
+	var doc struct {
+		Initial string `xml:"initial,attr"`
+		States  []struct {
+			ID          string `xml:"id,attr"`
+			Transitions []struct {
+				Event  string `xml:"event,attr"`
+				Target string `xml:"target,attr"`
+			} `xml:"transition"`
+		} `xml:"state"`
+	}
+	if err := xml.NewDecoder(strings.NewReader(got)).Decode(&doc); err != nil {
+		t.Fatalf("expected valid XML, got %v", err)
+	}
+	if doc.Initial != "closed" || len(doc.States) != 4 {
+		t.Errorf("unexpected initial state %q or number of states %d", doc.Initial, len(doc.States))
+	}
+	if tr := doc.States[0].Transitions; len(tr) != 1 || tr[0].Event != "kick" || tr[0].Target != "broken & bent" {
+		t.Errorf("unexpected transitions from 'broken' %v", tr)
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright (c) 2013 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fsm
+
+import "sort"
+
+// Validate checks the definition of the FSM and returns the problems found,
+// sorted by state and event. It returns:
+//
+// - UnreachableStateError for states that are not the initial state and not
+// the destination of any transition
+//
+// - DeadEndStateError for states without any outgoing transitions, unless they
+// are marked as final with SetFinalStates
+//
+// - ConflictingTransitionError for events defined more than once from the same
+// source state without guards and with different destinations
+//
+// The FSM is not modified.
+func (f *FSM) Validate() []error {
+	f.stateMu.RLock()
+	defer f.stateMu.RUnlock()
+	var errs []error
+
+	dsts := make(map[string]bool)
+	srcs := make(map[string]bool)
+	anySrc := false
+	for k, v := range f.transitions {
+		if k.src == AnyState {
+			anySrc = true
+		}
+		srcs[k.src] = true
+		for _, d := range v {
+			dsts[d.dst] = true
+		}
+	}
+
+	for _, state := range f.sortedStates() {
+		if state != f.initial && !dsts[state] {
+			errs = append(errs, UnreachableStateError{state})
+		}
+		if !srcs[state] && !anySrc && !f.final[state] {
+			errs = append(errs, DeadEndStateError{state})
+		}
+	}
+
+	return append(errs, f.conflicts()...)
+}
+
+// CheckDeterminism returns a ConflictingTransitionError for every event and
+// source state with more than one unguarded transition to different
+// destinations, sorted by source state and event, since only the first of
+// them can ever be performed. Guarded transitions are not considered
+// ambiguous.
+func (f *FSM) CheckDeterminism() []error {
+	f.stateMu.RLock()
+	defer f.stateMu.RUnlock()
+	return f.conflicts()
+}
+
+// IsDeterministic returns true if CheckDeterminism finds no ambiguous
+// transitions.
+func (f *FSM) IsDeterministic() bool {
+	f.stateMu.RLock()
+	defer f.stateMu.RUnlock()
+	return len(f.conflicts()) == 0
+}
+
+// conflicts returns a ConflictingTransitionError for every event and source
+// state with more than one unguarded destination, sorted by source state and
+// event. stateMu must be held.
+func (f *FSM) conflicts() []error {
+	var keys []eKey
+	for k, v := range f.transitions {
+		var dst string
+		unguarded := false
+		for _, d := range v {
+			if d.guard != nil {
+				continue
+			}
+			if unguarded && d.dst != dst {
+				keys = append(keys, k)
+				break
+			}
+			unguarded = true
+			dst = d.dst
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].src != keys[j].src {
+			return keys[i].src < keys[j].src
+		}
+		return keys[i].event < keys[j].event
+	})
+
+	var errs []error
+	for _, k := range keys {
+		errs = append(errs, ConflictingTransitionError{k.event, k.src})
+	}
+	return errs
+}
@@ -0,0 +1,72 @@
+// Copyright (c) 2013 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fsm
+
+import "context"
+
+// stateWaiter is a WaitForState call waiting for a state.
+type stateWaiter struct {
+	state string
+	ch    chan struct{}
+}
+
+// WaitForState blocks until the FSM is in state, returning nil, or until ctx
+// is done, returning ctx.Err(). It returns immediately if the FSM already is
+// in state. A state that is entered and left again while waiting, for example
+// by another goroutine, still counts as reached.
+func (f *FSM) WaitForState(ctx context.Context, state string) error {
+	state = f.normalize(state)
+	f.stateMu.Lock()
+	if f.current == state {
+		f.stateMu.Unlock()
+		return nil
+	}
+	ch := make(chan struct{})
+	f.waiters = append(f.waiters, stateWaiter{state, ch})
+	f.stateMu.Unlock()
+
+	select {
+	case <-ch:
+		return nil
+	case <-ctx.Done():
+		f.stateMu.Lock()
+		defer f.stateMu.Unlock()
+		for i, w := range f.waiters {
+			if w.ch == ch {
+				f.waiters = append(f.waiters[:i], f.waiters[i+1:]...)
+				return ctx.Err()
+			}
+		}
+		// The state was reached while the context was done.
+		return nil
+	}
+}
+
+// notifyWaiters wakes up the WaitForState calls waiting for state. stateMu
+// must be held.
+func (f *FSM) notifyWaiters(state string) {
+	waiters := f.waiters[:0]
+	for _, w := range f.waiters {
+		if w.state == state {
+			close(w.ch)
+		} else {
+			waiters = append(waiters, w)
+		}
+	}
+	for i := len(waiters); i < len(f.waiters); i++ {
+		f.waiters[i] = stateWaiter{}
+	}
+	f.waiters = waiters
+}
@@ -0,0 +1,88 @@
+// Copyright (c) 2013 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fsm
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestWaitForState(t *testing.T) {
+	fsm := NewFSM(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "running"},
+			{Name: "stop", Src: []string{"running"}, Dst: "end"},
+		},
+		Callbacks{},
+	)
+
+	done := make(chan error)
+	go func() {
+		done <- fsm.WaitForState(context.Background(), "end")
+	}()
+
+	// Wait for the waiter to be registered before firing the events.
+	for {
+		fsm.stateMu.RLock()
+		n := len(fsm.waiters)
+		fsm.stateMu.RUnlock()
+		if n == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	fsm.Event("run")
+	select {
+	case err := <-done:
+		t.Fatalf("expected to still wait in 'running', got %v", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	fsm.Event("stop")
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("expected no error, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected WaitForState to return after entering 'end'")
+	}
+
+	if err := fsm.WaitForState(context.Background(), "end"); err != nil {
+		t.Errorf("expected no error in the current state, got %v", err)
+	}
+}
+
+func TestWaitForStateContext(t *testing.T) {
+	fsm := NewFSM(
+		"start",
+		Events{
+			{Name: "run", Src: []string{"start"}, Dst: "end"},
+		},
+		Callbacks{},
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := fsm.WaitForState(ctx, "end"); err != context.DeadlineExceeded {
+		t.Errorf("expected 'context.DeadlineExceeded', got %v", err)
+	}
+	if len(fsm.waiters) != 0 {
+		t.Error("expected the waiter to be removed")
+	}
+}